@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultAssertCount = 30
+
+// percentFlag is a percentage given as "1%" or "1".
+type percentFlag float64
+
+func (p *percentFlag) String() string {
+	return strconv.FormatFloat(float64(*p), 'f', -1, 64) + "%"
+}
+
+func (p *percentFlag) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || v < 0 || v > 100 {
+		return fmt.Errorf("invalid percentage %q", s)
+	}
+	*p = percentFlag(v)
+	return nil
+}
+
+type targetVerdict struct {
+	Target     string   `json:"target"`
+	Pass       bool     `json:"pass"`
+	Probes     int      `json:"probes"`
+	LossPct    float64  `json:"loss_pct"`
+	AvgMs      float64  `json:"avg_ms"`
+	P95Ms      float64  `json:"p95_ms"`
+	P99Ms      float64  `json:"p99_ms"`
+	Violations []string `json:"violations,omitempty"`
+}
+
+type verdict struct {
+	Pass    bool            `json:"pass"`
+	Targets []targetVerdict `json:"targets"`
+}
+
+// assertBudget checks every target against the --max-* budget flags.
+func assertBudget(targets []*Target) verdict {
+	v := verdict{Pass: true}
+	for _, t := range targets {
+		tv := checkBudget(t)
+		v.Pass = v.Pass && tv.Pass
+		v.Targets = append(v.Targets, tv)
+	}
+	return v
+}
+
+func checkBudget(t *Target) targetVerdict {
+	stats := t.Stats
+	stats.Lock()
+	defer stats.Unlock()
+
+	tv := targetVerdict{Target: t.Addr(), Probes: stats.Attempted, LossPct: stats.lossPercent(), AvgMs: stats.averageTime()}
+	if stats.Samples != nil {
+		tv.P95Ms = quantileMs(stats.Samples, 0.95)
+		tv.P99Ms = quantileMs(stats.Samples, 0.99)
+	}
+
+	if max := float64(maxLoss); tv.LossPct > max {
+		tv.Violations = append(tv.Violations, fmt.Sprintf("loss %.2f%% > %.2f%%", tv.LossPct, max))
+	}
+	if stats.Connected == 0 {
+		tv.Violations = append(tv.Violations, "no successful probes")
+	}
+	checkMs := func(name string, got float64, max time.Duration) {
+		if max > 0 && got > ms(max) {
+			tv.Violations = append(tv.Violations, fmt.Sprintf("%s %s > %s", name, fmtMs(got), max))
+		}
+	}
+	checkMs("avg", tv.AvgMs, *maxAvg)
+	checkMs("p95", tv.P95Ms, *maxP95)
+	checkMs("p99", tv.P99Ms, *maxP99)
+
+	tv.Pass = len(tv.Violations) == 0
+	return tv
+}
+
+// printVerdict writes v as JSON to stdout and exits 1 if the budget was
+// violated.
+func printVerdict(v verdict) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	if !v.Pass {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const bannerTimeout = 2 * time.Second
+
+// readBanner returns whatever the service sends first on conn, such as an
+// SSH version string or SMTP greeting, up to size bytes.
+func readBanner(conn net.Conn, size int) string {
+	conn.SetReadDeadline(time.Now().Add(bannerTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, size)
+	n, _ := conn.Read(buf)
+	return cleanBanner(buf[:n])
+}
+
+// cleanBanner trims trailing line endings and escapes anything that is not
+// printable so a binary greeting cannot mess up the terminal.
+func cleanBanner(b []byte) string {
+	s := strings.TrimRight(string(b), "\r\n\x00")
+	q := strconv.QuoteToGraphic(s)
+	return q[1 : len(q)-1]
+}
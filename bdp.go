@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// bandwidthFlag is a link speed in bits per second, given as "100Mbit",
+// "1Gbps" or "500M", or in bytes as "12.5MB/s"; prefixes are decimal.
+type bandwidthFlag float64
+
+func (b *bandwidthFlag) String() string {
+	if *b == 0 {
+		return ""
+	}
+	return fmtBandwidth(float64(*b))
+}
+
+func (b *bandwidthFlag) Set(s string) error {
+	v := strings.TrimSpace(s)
+	unit := 1.0
+	for _, suffix := range []string{"B/s", "Bps"} {
+		if strings.HasSuffix(v, suffix) {
+			v, unit = strings.TrimSuffix(v, suffix), 8
+		}
+	}
+	if unit == 1 {
+		for _, suffix := range []string{"bit/s", "bps", "bit", "b/s"} {
+			if strings.HasSuffix(v, suffix) {
+				v = strings.TrimSuffix(v, suffix)
+				break
+			}
+		}
+	}
+	if v != "" {
+		if i := strings.IndexByte("kKMGT", v[len(v)-1]); i >= 0 {
+			unit *= []float64{1e3, 1e3, 1e6, 1e9, 1e12}[i]
+			v = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid bandwidth %q, want e.g. 100Mbit, 1Gbit or 12.5MB/s", s)
+	}
+	*b = bandwidthFlag(n * unit)
+	return nil
+}
+
+func fmtBandwidth(bps float64) string {
+	for _, u := range []struct {
+		div  float64
+		name string
+	}{{1e12, "Tbit/s"}, {1e9, "Gbit/s"}, {1e6, "Mbit/s"}, {1e3, "kbit/s"}} {
+		if bps >= u.div {
+			return strconv.FormatFloat(bps/u.div, 'f', -1, 64) + " " + u.name
+		}
+	}
+	return strconv.FormatFloat(bps, 'f', -1, 64) + " bit/s"
+}
+
+// bdp is the bandwidth-delay product in bytes: how much data must be in
+// flight to keep a link of bps busy over a round trip of rtt.
+func bdp(bps float64, rtt time.Duration) float64 {
+	return bps * rtt.Seconds() / 8
+}
+
+// printBDP turns the measured round trips into window and buffer sizes for
+// --bandwidth. The window must hold the BDP at the slower round trips too,
+// so p95 is used for the advice; the socket buffers are twice that, since
+// the kernel keeps part of each for bookkeeping, rounded up to a power of
+// two.
+func printBDP(avg, p95 time.Duration) {
+	bps := float64(bandwidth)
+	window := bdp(bps, p95)
+	buffer := math.Pow(2, math.Ceil(math.Log2(2*window)))
+	logger.Printf("Bandwidth-delay product at %s: "+color.CyanString("%s")+" at the %s average, "+color.CyanString("%s")+" at the %s p95\n",
+		fmtBandwidth(bps), fmtBytes(bdp(bps, avg)), fmtMs(ms(avg)), fmtBytes(window), fmtMs(ms(p95)))
+	logger.Printf(" Recommended TCP window: at least "+color.CyanString("%s")+"; socket buffers: "+color.CyanString("%s")+"\n", fmtBytes(window), fmtBytes(buffer))
+
+	rmem, wmem, ok := tcpBufferMax()
+	if !ok {
+		return
+	}
+	if float64(rmem) >= buffer && float64(wmem) >= buffer {
+		logger.Printf(" The tcp_rmem and tcp_wmem maximums (%s, %s) already allow that\n", fmtBytes(float64(rmem)), fmtBytes(float64(wmem)))
+		return
+	}
+	// The smaller values are the kernel defaults.
+	var settings []string
+	if float64(rmem) < buffer {
+		settings = append(settings, fmt.Sprintf("net.core.rmem_max=%[1]d net.ipv4.tcp_rmem='4096 131072 %[1]d'", int64(buffer)))
+	}
+	if float64(wmem) < buffer {
+		settings = append(settings, fmt.Sprintf("net.core.wmem_max=%[1]d net.ipv4.tcp_wmem='4096 16384 %[1]d'", int64(buffer)))
+	}
+	logger.Printf(color.YellowString(" The tcp_rmem and tcp_wmem maximums (%s, %s) cap a single connection below %s; raise them:\n", fmtBytes(float64(rmem)), fmtBytes(float64(wmem)), fmtBandwidth(bps)))
+	logger.Printf("  sysctl -w %s\n", strings.Join(settings, " "))
+}
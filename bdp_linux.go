@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpBufferMax reads the largest receive and send buffers the kernel lets
+// TCP grow a connection's buffers to, the last of the three tcp_rmem and
+// tcp_wmem values.
+func tcpBufferMax() (rmem, wmem int64, ok bool) {
+	read := func(name string) (int64, bool) {
+		data, err := os.ReadFile("/proc/sys/net/ipv4/" + name)
+		if err != nil {
+			return 0, false
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != 3 {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(fields[2], 10, 64)
+		return n, err == nil
+	}
+	rmem, rok := read("tcp_rmem")
+	wmem, wok := read("tcp_wmem")
+	return rmem, wmem, rok && wok
+}
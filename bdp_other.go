@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func tcpBufferMax() (rmem, wmem int64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A checker does the least exchange after connecting that shows the
+// service behind the port is healthy, and returns an error if it is not.
+type checker func(conn net.Conn) error
+
+// checkers are the protocols --check can speak.
+var checkers = map[string]checker{
+	"smtp":            checkSMTP,
+	"ftp":             checkFTP,
+	"redis":           checkRedis,
+	"mysql-handshake": checkMySQL,
+}
+
+func checkNames() []string {
+	names := make([]string, 0, len(checkers))
+	for name := range checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkProtocol validates --check.
+func checkProtocol() error {
+	if *checkName == "" {
+		return nil
+	}
+	if _, ok := checkers[*checkName]; !ok {
+		return fmt.Errorf("unknown check %q, want %s", *checkName, strings.Join(checkNames(), ", "))
+	}
+	return nil
+}
+
+// protocolError marks a probe that connected but found the service not
+// answering as --check expects.
+type protocolError struct {
+	check string
+	err   error
+}
+
+func (e *protocolError) Error() string { return e.check + ": " + e.err.Error() }
+func (e *protocolError) Unwrap() error { return e.err }
+
+// runCheck runs the --check exchange on conn within the probe timeout.
+func runCheck(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(*probeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := checkers[*checkName](conn); err != nil {
+		return &protocolError{*checkName, err}
+	}
+	return nil
+}
+
+// checkSMTP expects a 220 greeting and a 250 answer to EHLO, then says
+// QUIT.
+func checkSMTP(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+	if _, err := tp.Cmd("EHLO paping"); err != nil {
+		return err
+	}
+	if _, _, err := tp.ReadResponse(250); err != nil {
+		return err
+	}
+	tp.Cmd("QUIT")
+	return nil
+}
+
+// checkFTP expects a 220 greeting and a 221 answer to QUIT.
+func checkFTP(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+	if _, err := tp.Cmd("QUIT"); err != nil {
+		return err
+	}
+	_, _, err := tp.ReadResponse(221)
+	return err
+}
+
+// checkRedis sends PING and expects PONG. A server that wants a password
+// answers NOAUTH, which still shows it is up and speaking Redis; errors
+// such as LOADING or MASTERDOWN fail the check.
+func checkRedis(conn net.Conn) error {
+	if _, err := io.WriteString(conn, "*1\r\n$4\r\nPING\r\n"); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case line == "+PONG", strings.HasPrefix(line, "-NOAUTH"):
+		return nil
+	case strings.HasPrefix(line, "-"):
+		return errors.New(line[1:])
+	}
+	return fmt.Errorf("unexpected reply %q to PING", cleanBanner([]byte(line)))
+}
+
+// checkMySQL reads the initial handshake packet, which MySQL and MariaDB
+// send right after accepting, and fails on an error packet such as the
+// one for a host blocked after too many connection errors.
+func checkMySQL(conn net.Conn) error {
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if size == 0 {
+		return errors.New("empty handshake packet")
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return err
+	}
+	switch payload[0] {
+	case 10:
+		return nil
+	case 0xff:
+		if len(payload) < 3 {
+			return errors.New("truncated error packet")
+		}
+		code := binary.LittleEndian.Uint16(payload[1:3])
+		msg := payload[3:]
+		if len(msg) >= 6 && msg[0] == '#' {
+			msg = msg[6:]
+		}
+		return fmt.Errorf("error %d: %s", code, cleanBanner(msg))
+	}
+	return fmt.Errorf("unexpected handshake protocol version %d", payload[0])
+}
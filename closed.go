@@ -0,0 +1,34 @@
+package main
+
+import "errors"
+
+// errPortOpen fails a probe that connected although --expect-closed says
+// the port should not accept connections.
+var errPortOpen = errors.New("port is open")
+
+// invertOutcome turns the outcome of a probe around for --expect-closed: a
+// connection that was refused, timed out or found no route is the success
+// and one that got through is the failure. Errors that say nothing about
+// the port, such as a failed DNS lookup, stay failures.
+func invertOutcome(r *Result, err error) error {
+	if err == nil {
+		r.RTT = 0
+		return errPortOpen
+	}
+	switch c := classify(err); c {
+	case failRefused, failTimeout, failUnreachable:
+		r.Closed = c
+		return nil
+	}
+	r.RTT = 0
+	return err
+}
+
+// stateWords names the states announced when a target goes down and comes
+// back. With --expect-closed it is the port being open that is wrong.
+func stateWords() (down, up string) {
+	if *expectClosed {
+		return "OPEN", "CLOSED"
+	}
+	return "DOWN", "UP"
+}
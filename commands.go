@@ -0,0 +1,599 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// command is a paping subcommand. Flags are shared by all commands and may
+// appear anywhere on the command line; flags lists the ones that matter to
+// this command, which is all "paping <command> -h" shows and all it
+// accepts.
+type command struct {
+	name    string
+	usage   []string
+	summary string
+	flags   [][]string
+	run     func(args []string)
+}
+
+// Flag groups shared by several commands.
+var (
+	outputFlags   = []string{"layout", "format", "log-level", "print-config", "q", "show", "only-failures", "max-lines-per-sec", "v", "no-color"}
+	lookupFlags   = []string{"no-lookup", "lookup-provider", "lookup-token", "maxmind-db", "lookup-timeout", "lookup-max-failures", "lookup-ttl", "lookup-cache", "info-fields", "rdns", "resolve-each"}
+	dialFlags     = []string{"proto", "alpn", "w", "dns", "user-perceived", "expect-closed", "half-open", "interface", "tos", "dscp", "ttl", "sockopt", "wg-config", "proxy-chain", "proxy-protocol", "banner", "banner-size", "edge-id-header", "edge-tls", "breakdown", "probe-size", "check"}
+	scheduleFlags = []string{"preset", "count", "interval", "interval-jitter", "adaptive", "flood", "max-concurrent", "rate", "burst", "keepalive", "warm", "warm-gap", "retries", "retry-delay"}
+	statsFlags    = []string{"estimator", "sample-cap", "units-format", "thousands-sep", "seconds-above", "voip", "bandwidth", "summary-every"}
+	spikeFlags    = []string{"spike-threshold", "spike-ceiling", "spike-alert"}
+	sinkFlagNames = []string{"sink", "rotate-size", "rotate-every", "retain", "retain-size", "store", "ics", "webhook", "webhook-batch", "webhook-interval", "webhook-secret"}
+)
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name: "ping",
+			usage: []string{
+				"paping [ping] [flags] <host> <port>",
+				"paping [ping] [flags] <host:port>...",
+				"paping [ping] --wait-for [--timeout 5m] <host:port>...",
+				"paping [ping] --jobs-stdin",
+			},
+			summary: "probe targets continuously and print a report (the default)",
+			flags:   [][]string{{"tui", "compare", "duration", "start-at", "until", "speed-of-light", "game", "sample-load", "diagnose", "wait-for", "consecutive", "timeout", "jobs-stdin"}, scheduleFlags, dialFlags, lookupFlags, outputFlags, statsFlags, spikeFlags, sinkFlagNames},
+			run:     runPing,
+		},
+		{
+			name:    "scan",
+			usage:   []string{"paping scan [flags] <host>... -p 1-1024 [--concurrency 200]"},
+			summary: "check each port of a host once in parallel and list which answer",
+			flags:   [][]string{{"p", "concurrency", "rate", "burst", "w", "dns", "interface", "wg-config", "proxy-chain"}, {"log-level", "no-color"}},
+			run:     runScanCommand,
+		},
+		{
+			name:    "udp",
+			usage:   []string{"paping udp [flags] <host:port>"},
+			summary: "send numbered UDP datagrams to a paping echo server and report loss, duplicates, reordering and round trips",
+			flags:   [][]string{{"count", "interval", "interval-jitter", "w", "dns", "interface", "tos", "dscp", "ttl", "sockopt", "proxy-chain", "q"}, statsFlags, {"log-level", "no-color"}},
+			run:     runUDPCommand,
+		},
+		{
+			name:    "failover",
+			usage:   []string{"paping failover [--interfaces eth0,wwan0] [flags] <host:port>"},
+			summary: "probe a target from every interface at once and time how fast a primary path failure is noticed",
+			flags:   [][]string{{"interfaces", "count", "interval", "interval-jitter", "w", "dns", "proxy-chain", "q", "max-lines-per-sec", "log-level", "no-color"}},
+			run:     runFailoverCommand,
+		},
+		{
+			name:    "echo",
+			usage:   []string{"paping echo [--listen addr]"},
+			summary: "echo back TCP and UDP traffic, as the peer for udp, --keepalive and --warm",
+			flags:   [][]string{{"listen", "log-level", "no-color"}},
+			run:     runEchoCommand,
+		},
+		{
+			name:    "trace",
+			usage:   []string{"paping trace [flags] <host:port>...", "paping trace --mtr [flags] <host:port>"},
+			summary: "map the path to a target hop by hop, once or continuously with --mtr",
+			flags:   [][]string{{"mtr", "max-hops", "trace-queries"}, {"dns"}, lookupFlags, {"log-level", "no-color"}},
+			run:     runTraceCommand,
+		},
+		{
+			name:    "assert",
+			usage:   []string{"paping assert [flags] <host:port>... --max-p95 80ms --max-loss 1%"},
+			summary: "probe --count times and fail unless the latency budget holds",
+			flags:   [][]string{{"max-avg", "max-p95", "max-p99", "max-loss", "sample-load"}, scheduleFlags, dialFlags, lookupFlags, outputFlags, statsFlags, spikeFlags, sinkFlagNames},
+			run:     runAssert,
+		},
+		{
+			name:    "report",
+			usage:   []string{"paping report --store sqlite:<file> [--since 24h] [<host:port>...]"},
+			summary: "rebuild the report from results saved with --store",
+			flags:   [][]string{{"store", "since", "game"}, statsFlags, {"log-level", "no-color"}},
+			run:     runReportCommand,
+		},
+		{
+			name:    "record",
+			usage:   []string{"paping record --out baseline.json [flags] <host:port>..."},
+			summary: "probe like ping, then save a summary of the session for diff",
+			flags:   [][]string{{"out", "duration", "start-at", "until", "sample-load"}, scheduleFlags, dialFlags, lookupFlags, outputFlags, statsFlags, spikeFlags, sinkFlagNames},
+			run:     runRecord,
+		},
+		{
+			name:    "diff",
+			usage:   []string{"paping diff <before.json> <after.json>"},
+			summary: "compare two recorded sessions and fail if loss, latency or outages got worse",
+			flags:   [][]string{{"units-format", "thousands-sep", "seconds-above", "log-level", "no-color"}},
+			run:     runDiffCommand,
+		},
+		{
+			name:    "serve",
+			usage:   []string{"paping serve [--listen addr] [flags] [<host:port>...]"},
+			summary: "run as a service whose targets are managed over an HTTP API",
+			flags:   [][]string{{"listen", "agent-id", "peers", "gossip-interval", "gossip-token", "history", "sample-load"}, scheduleFlags, dialFlags, lookupFlags, outputFlags, statsFlags, spikeFlags, sinkFlagNames},
+			run:     runServe,
+		},
+		{
+			name:    "completion",
+			usage:   []string{"paping completion bash|zsh|fish|powershell"},
+			summary: "print a script that completes commands and flags in the given shell",
+			run:     runCompletionCommand,
+		},
+	}
+}
+
+// selectorFlags are the older flags that pick a command, by the command
+// they pick; they are accepted by it alone.
+var selectorFlags = map[string]string{
+	"trace":  "trace",
+	"daemon": "serve",
+}
+
+// foreignFlags returns those of the flags named in set that c does not
+// take, so that e.g. "paping scan --tui" fails instead of silently doing
+// nothing.
+func foreignFlags(c *command, set []string) []string {
+	takes := make(map[string]bool)
+	for _, name := range commandFlags(c) {
+		takes[name] = true
+	}
+	var foreign []string
+	for _, name := range set {
+		if !takes[name] && selectorFlags[name] != c.name {
+			foreign = append(foreign, flagArg(name))
+		}
+	}
+	return foreign
+}
+
+// checkCommandFlags rejects flags given on the command line that c does
+// not take. Flags set by presets are applied later and not checked.
+func checkCommandFlags(c *command) error {
+	var set []string
+	flag.Visit(func(f *flag.Flag) { set = append(set, f.Name) })
+	if foreign := foreignFlags(c, set); len(foreign) > 0 {
+		return fmt.Errorf("%s does not take %s; run \"paping help %s\" for its flags", c.name, strings.Join(foreign, ", "), c.name)
+	}
+	return nil
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// commandFor picks the command from the positional arguments, falling back
+// to ping so that bare "paping host port" keeps working. The --trace,
+// --mtr and --daemon flags still select their commands too.
+func commandFor(args []string) (*command, []string) {
+	if len(args) > 0 {
+		if args[0] == "help" {
+			return nil, args[1:]
+		}
+		if c := findCommand(args[0]); c != nil {
+			return c, args[1:]
+		}
+	}
+	switch {
+	case *traceMode || *mtrMode:
+		return findCommand("trace"), args
+	case *daemonMode:
+		return findCommand("serve"), args
+	}
+	return findCommand("ping"), args
+}
+
+// usage prints help for the command named on the command line, or the
+// list of commands when there is none.
+func usage() {
+	for _, arg := range os.Args[1:] {
+		if c := findCommand(arg); c != nil {
+			commandUsage(c)
+			return
+		}
+	}
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: paping [command] [flags] <host:port>...\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nWithout a command paping runs ping. Run \"paping help <command>\" for its flags.\n")
+}
+
+func commandUsage(c *command) {
+	out := flag.CommandLine.Output()
+	for i, u := range c.usage {
+		prefix := "Usage: "
+		if i > 0 {
+			prefix = "       "
+		}
+		fmt.Fprintf(out, "%s%s\n", prefix, u)
+	}
+	fmt.Fprintf(out, "\n%s.\n", strings.ToUpper(c.summary[:1])+c.summary[1:])
+	if len(c.flags) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+
+	// Print through a scratch FlagSet so the output matches flag.PrintDefaults.
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	for _, name := range commandFlags(c) {
+		if f := flag.Lookup(name); f != nil {
+			fs.Var(f.Value, f.Name, f.Usage)
+			fs.Lookup(name).DefValue = f.DefValue
+		}
+	}
+	fs.PrintDefaults()
+}
+
+// commandFlags returns the names of the flags that matter to c, sorted.
+func commandFlags(c *command) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, group := range c.flags {
+		for _, name := range group {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setup applies the flags shared by the probing commands, exiting on a
+// bad value.
+func setup() {
+	layers, err := configLayers()
+	if err == nil {
+		err = applyLayers(layers)
+	}
+	if err != nil {
+		fatal(2, err)
+	}
+	currentRun = newRunMeta()
+	if err := checkLayout(*layoutName); err != nil {
+		fatal(2, err)
+	}
+	if err := setFormat(*formatSpec); err != nil {
+		fatal(2, err)
+	}
+	if machineFormat() {
+		logger.SetOutput(color.Error)
+	}
+	if err := checkVerbosity(); err != nil {
+		fatal(2, err)
+	}
+	if err := checkEstimator(*estimatorName, *sampleCap); err != nil {
+		fatal(2, err)
+	}
+	if err := checkUnits(); err != nil {
+		fatal(2, err)
+	}
+	if geo, err = newGeoLookup(); err != nil {
+		fatal(2, err)
+	}
+	setResolver(*dnsServer)
+	if err := checkMarking(); err != nil {
+		fatal(2, err)
+	}
+	if dialer.DialContext, err = newDialContext(*ifaceName, *wgConfig, *proxyFlag); err != nil {
+		fatal(2, err)
+	}
+	if err := checkScheduleFlags(); err != nil {
+		fatal(2, err)
+	}
+	if *printConfig {
+		if err := printResolvedConfig(os.Stdout, layers); err != nil {
+			fatal(1, err)
+		}
+		os.Exit(0)
+	}
+	if *sampleLoad {
+		if err := startLoadSampler(); err != nil {
+			fatal(2, err)
+		}
+	}
+	inflight = make(chan struct{}, *maxConcurrent)
+	dialer.Timeout = *probeTimeout
+
+	if *webhookURL != "" {
+		sinkSpecs = append(sinkSpecs, "webhook:"+*webhookURL)
+	}
+	if *icsPath != "" {
+		sinkSpecs = append(sinkSpecs, "ics:"+*icsPath)
+	}
+	if *storeSpec != "" {
+		path, err := storePath(*storeSpec)
+		if err != nil {
+			fatal(2, err)
+		}
+		sinkSpecs = append(sinkSpecs, "sqlite:"+path)
+	}
+	if err := openSinks(sinkSpecs); err != nil {
+		fatal(2, err)
+	}
+}
+
+// mustParseTargets parses the target arguments, exiting with the command's
+// usage if they are invalid.
+func mustParseTargets(c *command, args []string) []*Target {
+	targets, err := parseTargets(args)
+	if err != nil {
+		diag.Error(err.Error())
+		commandUsage(c)
+		os.Exit(2)
+	}
+	return targets
+}
+
+func runPing(args []string) {
+	setup()
+
+	if *jobsStdin {
+		logger.SetOutput(io.Discard)
+		runJobs(os.Stdin, os.Stdout)
+		closeSinks()
+		return
+	}
+
+	targets := mustParseTargets(findCommand("ping"), args)
+
+	if *waitFor {
+		if !runWaitFor(targets) {
+			closeSinks()
+			os.Exit(1)
+		}
+		closeSinks()
+		return
+	}
+
+	if *compareProtos != "" {
+		stopOnSignal(targets)
+		if waitForWindow(targets) {
+			runCompare(targets)
+		}
+		closeSinks()
+		return
+	}
+
+	var dash *dashboard
+	if *tuiMode {
+		logger.SetOutput(io.Discard)
+		setDiagOutput(io.Discard)
+		dash = startDashboard(targets)
+	} else if *quiet {
+		logger.SetOutput(io.Discard)
+	}
+
+	printMarking()
+	if *speedOfLight {
+		locateTargets(targets)
+	}
+	stopOnSignal(targets)
+	if !waitForWindow(targets) {
+		if dash != nil {
+			dash.Close()
+			setDiagOutput(color.Error)
+		}
+		closeSinks()
+		return
+	}
+	stopSummaries := startSummaries(targets, *summaryEvery)
+	runAll(targets)
+
+	stopSummaries()
+	if dash != nil {
+		dash.Close()
+		setDiagOutput(color.Error)
+	}
+	logger.SetOutput(statusOutput())
+	closeSinks()
+	printReport([]RunMeta{currentRun}, targets)
+}
+
+// stopOnSignal stops every target on the first shutdown signal, so that
+// the probing loops return once the probes in flight have finished and
+// their results are recorded, leaving the caller to flush the sinks and
+// print the report. A second signal flushes the sinks and quits at once.
+func stopOnSignal(targets []*Target) {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, shutdownSignals...)
+	go func() {
+		<-c
+		for _, t := range targets {
+			t.Stop()
+		}
+		if n := len(inflight); n > 0 {
+			diag.Info(fmt.Sprintf("Waiting for %d probes in flight; interrupt again to quit now", n), "in_flight", n)
+		}
+		<-c
+		closeSinks()
+		os.Exit(1)
+	}()
+}
+
+func runScanCommand(args []string) {
+	setup()
+	if err := runScan(args); err != nil {
+		diag.Error(err.Error())
+		commandUsage(findCommand("scan"))
+		os.Exit(2)
+	}
+	closeSinks()
+}
+
+func runUDPCommand(args []string) {
+	setup()
+	targets := mustParseTargets(findCommand("udp"), args)
+	if len(targets) != 1 {
+		fatal(1, errors.New("udp takes exactly one target"))
+	}
+	stopOnSignal(targets)
+	if err := runUDP(targets[0]); err != nil {
+		fatal(1, err)
+	}
+	closeSinks()
+}
+
+func runFailoverCommand(args []string) {
+	setup()
+	targets := mustParseTargets(findCommand("failover"), args)
+	if len(targets) != 1 {
+		fatal(1, errors.New("failover takes exactly one target"))
+	}
+	t := targets[0]
+	stopOnSignal(targets)
+	if err := runFailover(t); err != nil {
+		fatal(1, err)
+	}
+	closeSinks()
+}
+
+func runEchoCommand(args []string) {
+	if len(args) > 0 {
+		commandUsage(findCommand("echo"))
+		os.Exit(2)
+	}
+	if err := runEcho(*listenAddr); err != nil {
+		fatal(1, err)
+	}
+}
+
+func runTraceCommand(args []string) {
+	setup()
+	targets := mustParseTargets(findCommand("trace"), args)
+
+	if *mtrMode {
+		if len(targets) != 1 {
+			fatal(1, errors.New("--mtr takes exactly one target"))
+		}
+		stop := make(chan struct{})
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, shutdownSignals...)
+		go func() {
+			<-c
+			close(stop)
+		}()
+		if err := runMTR(targets[0], stop); err != nil {
+			fatal(1, err)
+		}
+		return
+	}
+
+	stopOnSignal(targets)
+	for _, t := range targets {
+		if t.ctx.Err() != nil {
+			break
+		}
+		if err := runTrace(t); err != nil {
+			fatal(1, err)
+		}
+	}
+	closeSinks()
+}
+
+func runAssert(args []string) {
+	setup()
+	targets := mustParseTargets(findCommand("assert"), args)
+
+	if *count == 0 {
+		*count = defaultAssertCount
+	}
+	logger.SetOutput(color.Error)
+	if *quiet {
+		logger.SetOutput(io.Discard)
+	}
+	stopOnSignal(targets)
+	runAll(targets)
+	closeSinks()
+	logger.SetOutput(color.Error)
+	printReport([]RunMeta{currentRun}, targets)
+	printVerdict(assertBudget(targets))
+}
+
+func runReportCommand(args []string) {
+	if err := checkEstimator(*estimatorName, *sampleCap); err != nil {
+		fatal(2, err)
+	}
+	if err := checkUnits(); err != nil {
+		fatal(2, err)
+	}
+	if err := runReport(args); err != nil {
+		fatal(2, err)
+	}
+}
+
+func runRecord(args []string) {
+	if *sessionOut == "" {
+		diag.Error("record needs --out")
+		commandUsage(findCommand("record"))
+		os.Exit(2)
+	}
+	setup()
+	targets := mustParseTargets(findCommand("record"), args)
+
+	if *quiet {
+		logger.SetOutput(io.Discard)
+	}
+	stopOnSignal(targets)
+	if !waitForWindow(targets) {
+		closeSinks()
+		return
+	}
+	runAll(targets)
+	closeSinks()
+	logger.SetOutput(statusOutput())
+	printReport([]RunMeta{currentRun}, targets)
+	if err := writeSession(*sessionOut, newSession(targets)); err != nil {
+		fatal(1, err)
+	}
+	logger.Printf("\nSession saved to %s\n", *sessionOut)
+}
+
+func runDiffCommand(args []string) {
+	if len(args) != 2 {
+		commandUsage(findCommand("diff"))
+		os.Exit(2)
+	}
+	if err := checkUnits(); err != nil {
+		fatal(2, err)
+	}
+	before, err := loadSession(args[0])
+	if err != nil {
+		fatal(1, err)
+	}
+	after, err := loadSession(args[1])
+	if err != nil {
+		fatal(1, err)
+	}
+	if diffSessions(before, after, args[0], args[1]) {
+		os.Exit(1)
+	}
+}
+
+func runServe(args []string) {
+	setup()
+	var targets []*Target
+	if len(args) > 0 {
+		targets = mustParseTargets(findCommand("serve"), args)
+	}
+	if err := runDaemon(*listenAddr, targets); err != nil {
+		fatal(1, err)
+	}
+}
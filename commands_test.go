@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForeignFlags(t *testing.T) {
+	tests := []struct {
+		command string
+		set     []string
+		want    []string
+	}{
+		{"ping", []string{"rate", "tui", "webhook"}, nil},
+		{"scan", []string{"p", "tui"}, []string{"--tui"}},
+		{"echo", []string{"listen", "webhook"}, []string{"--webhook"}},
+		{"report", []string{"store", "rate"}, []string{"--rate"}},
+		{"trace", []string{"trace", "mtr"}, nil},
+		{"serve", []string{"daemon", "listen"}, nil},
+		{"ping", []string{"daemon", "w"}, []string{"--daemon"}},
+		{"completion", []string{"v"}, []string{"-v"}},
+	}
+	for _, tt := range tests {
+		if got := foreignFlags(findCommand(tt.command), tt.set); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s with %v: foreign %v, want %v", tt.command, tt.set, got, tt.want)
+		}
+	}
+}
+
+// Every flag must be taken by some command, or it could never be given.
+func TestEveryFlagHasCommand(t *testing.T) {
+	taken := make(map[string]bool)
+	for _, c := range commands {
+		for _, name := range commandFlags(c) {
+			if flag.Lookup(name) == nil {
+				t.Errorf("%s lists unknown flag %q", c.name, name)
+			}
+			taken[name] = true
+		}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !taken[f.Name] && selectorFlags[f.Name] == "" && !strings.HasPrefix(f.Name, "test.") {
+			t.Errorf("no command takes --%s", f.Name)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const compareWidth = 10
+
+// compareProbe times one probe of t at ip over some protocol.
+type compareProbe func(t *Target, ip string) (time.Duration, error)
+
+var compareProbes = map[string]compareProbe{
+	"tcp":   compareTCP,
+	"icmp":  compareICMP,
+	"http":  compareHTTP("http"),
+	"https": compareHTTP("https"),
+	"quic":  compareQUIC,
+}
+
+// compareColumn is one protocol of a --compare run.
+type compareColumn struct {
+	name  string
+	probe compareProbe
+	stats *ConnectionStats
+
+	// Set by the latest round.
+	rtt time.Duration
+	err error
+}
+
+// parseCompare checks a --compare list such as "tcp,icmp,https".
+func parseCompare(list string) ([]string, error) {
+	names := strings.Split(list, ",")
+	if len(names) < 2 {
+		return nil, fmt.Errorf("--compare needs at least two protocols, got %q", list)
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if compareProbes[name] == nil {
+			return nil, fmt.Errorf("--compare: unknown protocol %q, want tcp, icmp, http, https or quic", name)
+		}
+		if name == protoQUIC {
+			if err := checkProto(name); err != nil {
+				return nil, err
+			}
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("--compare: %s given twice", name)
+		}
+		seen[name] = true
+	}
+	return names, nil
+}
+
+// runCompare probes every target over each --compare protocol at once
+// every --interval, printing one aligned line per round, until --count is
+// used up or the targets are stopped, then prints the side-by-side report.
+func runCompare(targets []*Target) {
+	names, _ := parseCompare(*compareProtos)
+	all := make([][]*compareColumn, len(targets))
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		cols := make([]*compareColumn, len(names))
+		for j, name := range names {
+			cols[j] = &compareColumn{name: name, probe: compareProbes[name], stats: &ConnectionStats{}}
+		}
+		all[i] = cols
+
+		wg.Add(1)
+		go func(t *Target) {
+			defer wg.Done()
+			for sent := 0; !t.done(sent); sent++ {
+				if sent > 0 {
+					t.sleep(nextInterval())
+				}
+				if !t.limit.wait(t.ctx) {
+					break
+				}
+				compareRound(t, cols)
+			}
+		}(t)
+	}
+	wg.Wait()
+
+	probeLines.flush()
+	for i, t := range targets {
+		printCompareReport(t, all[i])
+	}
+}
+
+// compareRound resolves t once and probes it over every protocol in
+// parallel, recording and printing the outcome.
+func compareRound(t *Target, cols []*compareColumn) {
+	seq := int(t.seq.Add(1))
+	ips, _, err := resolve(t.Host)
+	if err != nil {
+		for _, c := range cols {
+			c.rtt, c.err = 0, err
+			c.stats.recordFailure(classify(err), err)
+		}
+		printCompareLine(t, seq, cols)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range cols {
+		wg.Add(1)
+		go func(c *compareColumn) {
+			defer wg.Done()
+			acquireSlot()
+			defer releaseSlot()
+
+			r := Result{Time: time.Now(), Target: t.Addr(), Host: t.Host, Port: t.Port, Proto: c.name, Seq: seq, IP: ips[0]}
+			c.rtt, c.err = c.probe(t, ips[0])
+			if c.err != nil {
+				r.Category, r.Error = classify(c.err), c.err.Error()
+				c.stats.recordFailure(r.Category, c.err)
+			} else {
+				r.Success, r.RTT = true, ms(c.rtt)
+				c.stats.recordSuccess(c.rtt, "")
+			}
+			emit(r)
+		}(c)
+	}
+	wg.Wait()
+	printCompareLine(t, seq, cols)
+}
+
+func printCompareLine(t *Target, seq int, cols []*compareColumn) {
+	if *quiet || !probeLines.allow() {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s seq=%-5d", t.Addr(), seq)
+	for _, c := range cols {
+		if c.err != nil {
+			fmt.Fprintf(&b, "  %s %s", c.name, color.RedString("%-*s", compareWidth, classify(c.err)))
+		} else {
+			fmt.Fprintf(&b, "  %s %s", c.name, color.GreenString("%-*s", compareWidth, fmtMs(ms(c.rtt))))
+		}
+	}
+	logger.Println(strings.TrimRight(b.String(), " "))
+}
+
+func printCompareReport(t *Target, cols []*compareColumn) {
+	logger.Printf("\nProtocol comparison for "+color.CyanString("%s")+":\n", t.Addr())
+	logger.Printf(" %-8s %9s %7s %8s %10s %10s %10s %10s\n", "Protocol", "Attempted", "Failed", "Loss", "Min", "Avg", "p95", "Max")
+	for _, c := range cols {
+		s := c.stats
+		s.Lock()
+		min, avg, p95, max := "-", "-", "-", "-"
+		if s.Connected > 0 {
+			min = fmtMs(ms(s.MinTime))
+			avg = fmtMs(ms(s.TotalTime / time.Duration(s.Connected)))
+			p95 = fmtMs(quantileMs(s.Samples, 0.95))
+			max = fmtMs(ms(s.MaxTime))
+		}
+		loss := color.CyanString("%7.2f%%", s.lossPercent())
+		if s.Failed > 0 {
+			loss = color.RedString("%7.2f%%", s.lossPercent())
+		}
+		logger.Printf(" %-8s %9d %7d %s %10s %10s %10s %10s\n", c.name, s.Attempted, s.Failed, loss, min, avg, p95, max)
+		s.Unlock()
+	}
+}
+
+func compareTCP(t *Target, ip string) (time.Duration, error) {
+	conn, took, err := t.Dialer.Dial(t.ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(t.Port)))
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return took, nil
+}
+
+func compareICMP(t *Target, ip string) (time.Duration, error) {
+	return icmpEcho(ip, *probeTimeout)
+}
+
+func compareQUIC(t *Target, ip string) (time.Duration, error) {
+	local, err := localAddr(*ifaceName)
+	if err != nil {
+		return 0, err
+	}
+	took, _, _, err := quicHandshake(local, net.JoinHostPort(ip, strconv.Itoa(t.Port)), t.Host)
+	return took, err
+}
+
+// compareHTTP times a HEAD request for / over a fresh connection, up to
+// the response headers. Any status counts as an answer, and certificates
+// are not verified since only reachability is measured.
+func compareHTTP(scheme string) compareProbe {
+	return func(t *Target, ip string) (time.Duration, error) {
+		addr := net.JoinHostPort(ip, strconv.Itoa(t.Port))
+		client := &http.Client{
+			Timeout: *probeTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					conn, _, err := t.Dialer.Dial(ctx, "tcp", addr)
+					return conn, err
+				},
+				TLSClientConfig:   &tls.Config{ServerName: t.Host, InsecureSkipVerify: true},
+				DisableKeepAlives: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		start := time.Now()
+		resp, err := client.Head(scheme + "://" + t.Addr() + "/")
+		if err != nil {
+			return 0, err
+		}
+		took := time.Since(start)
+		resp.Body.Close()
+		return took, nil
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completionShells write the completion script for each shell that
+// "paping completion" supports. The scripts are generated from the
+// commands and their flag groups, so they follow new flags without edits.
+var completionShells = map[string]func(w io.Writer){
+	"bash":       bashCompletion,
+	"zsh":        zshCompletion,
+	"fish":       fishCompletion,
+	"powershell": powershellCompletion,
+}
+
+var completionShellNames = []string{"bash", "zsh", "fish", "powershell"}
+
+func runCompletionCommand(args []string) {
+	if len(args) != 1 || completionShells[args[0]] == nil {
+		commandUsage(findCommand("completion"))
+		os.Exit(2)
+	}
+	completionShells[args[0]](os.Stdout)
+}
+
+// flagChoices lists the values the shells offer after the flags that take
+// one of a fixed set.
+func flagChoices() map[string][]string {
+	levels := make([]string, 0, len(logLevels))
+	for name := range logLevels {
+		levels = append(levels, name)
+	}
+	sort.Strings(levels)
+	return map[string][]string{
+		"check":     checkNames(),
+		"preset":    presetNames(),
+		"log-level": levels,
+		"format":    {formatText, formatJSON, formatCSV},
+		"proto":     {protoTCP, "tcp4", "tcp6", protoQUIC, "udp", "udp4", "udp6", "unix", "unixgram", "unixpacket"},
+	}
+}
+
+// choiceNames returns the flags of flagChoices in a stable order.
+func choiceNames(choices map[string][]string) []string {
+	names := make([]string, 0, len(choices))
+	for name := range choices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// flagArg is how a flag is written on the command line: -w for the
+// single-letter ones, --interval for the rest.
+func flagArg(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+func flagArgs(c *command) []string {
+	var args []string
+	for _, name := range commandFlags(c) {
+		args = append(args, flagArg(name))
+	}
+	return args
+}
+
+func isBoolFlag(name string) bool {
+	f := flag.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// completionWords is every word that selects a command, help included.
+func completionWords() []string {
+	words := []string{"help"}
+	for _, c := range commands {
+		words = append(words, c.name)
+	}
+	return words
+}
+
+// shQuote quotes s for bash, zsh and fish.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func bashCompletion(w io.Writer) {
+	choices := flagChoices()
+	fmt.Fprintf(w, `# bash completion for paping; load with: source <(paping completion bash)
+_paping() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd= i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		%s) cmd=${COMP_WORDS[i]}; break ;;
+		esac
+	done
+	case $prev in
+`, strings.Join(completionWords(), "|"))
+	for _, name := range choiceNames(choices) {
+		fmt.Fprintf(w, "\t-%[1]s|--%[1]s) COMPREPLY=($(compgen -W %[2]s -- \"$cur\")); return ;;\n", name, shQuote(strings.Join(choices[name], " ")))
+	}
+	fmt.Fprintf(w, "\tesac\n\tif [[ $cur == -* ]]; then\n\t\tcase ${cmd:-ping} in\n")
+	for _, c := range commands {
+		if args := flagArgs(c); len(args) > 0 {
+			fmt.Fprintf(w, "\t\t%s) COMPREPLY=($(compgen -W %s -- \"$cur\")) ;;\n", c.name, shQuote(strings.Join(args, " ")))
+		}
+	}
+	fmt.Fprintf(w, `		esac
+	elif [[ -z $cmd || $cmd == help ]]; then
+		COMPREPLY=($(compgen -W %s -- "$cur"))
+	elif [[ $cmd == completion ]]; then
+		COMPREPLY=($(compgen -W %s -- "$cur"))
+	fi
+}
+complete -o default -F _paping paping
+`, shQuote(strings.Join(completionWords()[1:], " ")), shQuote(strings.Join(completionShellNames, " ")))
+}
+
+func zshCompletion(w io.Writer) {
+	choices := flagChoices()
+	fmt.Fprintf(w, `#compdef paping
+# zsh completion for paping; load with: source <(paping completion zsh)
+_paping() {
+	local cmd= i
+	for ((i = 2; i < CURRENT; i++)); do
+		case $words[i] in
+		(%s) cmd=$words[i]; break ;;
+		esac
+	done
+	case $words[CURRENT-1] in
+`, strings.Join(completionWords(), "|"))
+	for _, name := range choiceNames(choices) {
+		fmt.Fprintf(w, "\t(-%[1]s|--%[1]s) compadd -- %[2]s; return ;;\n", name, strings.Join(choices[name], " "))
+	}
+	fmt.Fprintf(w, "\tesac\n\tif [[ $words[CURRENT] == -* ]]; then\n\t\tcase ${cmd:-ping} in\n")
+	for _, c := range commands {
+		if args := flagArgs(c); len(args) > 0 {
+			fmt.Fprintf(w, "\t\t(%s) compadd -- %s ;;\n", c.name, strings.Join(args, " "))
+		}
+	}
+	fmt.Fprintf(w, "\t\tesac\n\telif [[ -z $cmd || $cmd == help ]]; then\n\t\tlocal -a commands\n\t\tcommands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "\t\t\t%s\n", shQuote(c.name+":"+c.summary))
+	}
+	fmt.Fprintf(w, `		)
+		_describe command commands
+	elif [[ $cmd == completion ]]; then
+		compadd -- %s
+	else
+		_files
+	fi
+}
+compdef _paping paping
+`, strings.Join(completionShellNames, " "))
+}
+
+func fishCompletion(w io.Writer) {
+	choices := flagChoices()
+	words := completionWords()
+	fmt.Fprintf(w, "# fish completion for paping; load with: paping completion fish | source\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c paping -f -n %s -a %s -d %s\n", shQuote("not __fish_seen_subcommand_from "+strings.Join(words, " ")), c.name, shQuote(c.summary))
+	}
+	for _, c := range commands {
+		cond := "__fish_seen_subcommand_from " + c.name
+		if c.name == "ping" {
+			// ping is also what runs without a command.
+			var others []string
+			for _, word := range words {
+				if word != "ping" {
+					others = append(others, word)
+				}
+			}
+			cond = "not __fish_seen_subcommand_from " + strings.Join(others, " ")
+		}
+		for _, name := range commandFlags(c) {
+			f := flag.Lookup(name)
+			if f == nil {
+				continue
+			}
+			opt := "-l " + name
+			if len(name) == 1 {
+				opt = "-o " + name
+			}
+			switch {
+			case choices[name] != nil:
+				opt += " -x -a " + shQuote(strings.Join(choices[name], " "))
+			case !isBoolFlag(name):
+				opt += " -r"
+			}
+			fmt.Fprintf(w, "complete -c paping -n %s %s -d %s\n", shQuote(cond), opt, shQuote(f.Usage))
+		}
+	}
+	fmt.Fprintf(w, "complete -c paping -f -n '__fish_seen_subcommand_from completion' -a %s\n", shQuote(strings.Join(completionShellNames, " ")))
+}
+
+func powershellCompletion(w io.Writer) {
+	choices := flagChoices()
+	quoteAll := func(words []string) string {
+		quoted := make([]string, len(words))
+		for i, word := range words {
+			quoted[i] = psQuote(word)
+		}
+		return "@(" + strings.Join(quoted, ", ") + ")"
+	}
+	fmt.Fprintf(w, "# PowerShell completion for paping; load with: paping completion powershell | Out-String | Invoke-Expression\n")
+	fmt.Fprintf(w, "Register-ArgumentCompleter -Native -CommandName paping -ScriptBlock {\n")
+	fmt.Fprintf(w, "    param($wordToComplete, $commandAst, $cursorPosition)\n")
+	fmt.Fprintf(w, "    $summaries = @{\n        'help' = 'show the flags of a command'\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "        %s = %s\n", psQuote(c.name), psQuote(c.summary))
+	}
+	fmt.Fprintf(w, "    }\n    $flags = @{\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "        %s = %s\n", psQuote(c.name), quoteAll(flagArgs(c)))
+	}
+	fmt.Fprintf(w, "    }\n    $values = @{\n")
+	for _, name := range choiceNames(choices) {
+		fmt.Fprintf(w, "        %s = %s\n", psQuote("-"+name), quoteAll(choices[name]))
+		fmt.Fprintf(w, "        %s = %s\n", psQuote("--"+name), quoteAll(choices[name]))
+	}
+	fmt.Fprintf(w, `    }
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    if ($wordToComplete) {
+        $words = @($words | Select-Object -SkipLast 1)
+    }
+    $cmd = $null
+    foreach ($word in $words) {
+        if ($summaries.ContainsKey($word)) {
+            $cmd = $word
+            break
+        }
+    }
+    $prev = if ($words.Count -gt 0) { $words[-1] } else { '' }
+    if ($values.ContainsKey($prev)) {
+        $candidates = $values[$prev]
+    } elseif ($wordToComplete -like '-*') {
+        $candidates = $flags[$(if ($cmd) { $cmd } else { 'ping' })]
+    } elseif (-not $cmd -or $cmd -eq 'help') {
+        $candidates = $summaries.Keys | Where-Object { $_ -ne 'help' } | Sort-Object
+    } elseif ($cmd -eq 'completion') {
+        $candidates = %s
+    } else {
+        return
+    }
+    $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        $tip = if ($summaries.ContainsKey($_)) { $summaries[$_] } else { $_ }
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $tip)
+    }
+}
+`, quoteAll(completionShellNames))
+}
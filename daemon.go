@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const daemonShutdownTimeout = 5 * time.Second
+
+// daemon probes a set of targets that can be changed at runtime through a
+// small HTTP API:
+//
+//	GET    /targets                      list targets
+//	POST   /targets                      add {"target": "host:port"}
+//	DELETE /targets?target=h:p           remove a target and print its report
+//	GET    /stats                        per-target stats as JSON
+//	POST   /stop                         stop probing, print the report and exit
+//	GET    /status[?target=h:p]          health of targets as seen by every agent
+//	POST   /gossip                       exchange health views with another agent,
+//	                                     with --gossip-token as a bearer token
+//	POST   /maintenance?target=h:p       pause probing a target, with &for=30m for a while
+//	DELETE /maintenance?target=h:p       resume probing it
+//	POST   /resolve?target=h:p           resolve it again, dropping cached IP info
+//	POST   /reset?target=h:p             clear its stats
+//	POST   /burst?target=h:p[&count=10]  probe it count times back to back now,
+//	                                     at most 1000 and within --rate
+//	GET    /history?target=h:p           its probes kept by --history, with
+//	       [&from=6h][&to=...][&step=1m]  a time range and summed per step
+type daemon struct {
+	mu      sync.Mutex
+	targets []*Target
+	wg      sync.WaitGroup
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type targetStats struct {
+	Target    string         `json:"target"`
+	Attempted int            `json:"attempted"`
+	Connected int            `json:"connected"`
+	Failed    int            `json:"failed"`
+	LossPct   float64        `json:"loss_pct"`
+	MinMs     float64        `json:"min_ms"`
+	AvgMs     float64        `json:"avg_ms"`
+	MaxMs     float64        `json:"max_ms"`
+	P50Ms     float64        `json:"p50_ms"`
+	P95Ms     float64        `json:"p95_ms"`
+	P99Ms     float64        `json:"p99_ms"`
+	ISP       string         `json:"isp,omitempty"`
+	LastError string         `json:"last_error,omitempty"`
+	Failures  map[string]int `json:"failures,omitempty"`
+	// Maintenance is set while probing is paused by POST /maintenance.
+	Maintenance bool `json:"maintenance,omitempty"`
+}
+
+// runDaemon serves the control API on addr and probes targets, plus any
+// added later, until /stop is called or the process is interrupted.
+func runDaemon(addr string, targets []*Target) error {
+	if *gossipInterval <= 0 {
+		return errors.New("--gossip-interval must be positive")
+	}
+	if *historyRetain < 0 {
+		return errors.New("--history must not be negative")
+	}
+	if *peers != "" && *gossipToken == "" {
+		return errors.New("--peers needs --gossip-token")
+	}
+	d := &daemon{stop: make(chan struct{})}
+	for _, t := range targets {
+		d.add(t)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/targets", d.handleTargets)
+	mux.HandleFunc("/stats", d.handleStats)
+	mux.HandleFunc("/stop", d.handleStop)
+	mux.HandleFunc("/maintenance", d.handleMaintenance)
+	mux.HandleFunc("/resolve", d.handleResolve)
+	mux.HandleFunc("/reset", d.handleReset)
+	mux.HandleFunc("/burst", d.handleBurst)
+	if *historyRetain > 0 {
+		h := newHistory(*historyRetain)
+		sinksMu.Lock()
+		sinks = append(sinks, h)
+		sinksMu.Unlock()
+		mux.HandleFunc("/history", h.handleHistory)
+	}
+
+	g := newGossip(*agentID, peerList(*peers), *gossipToken)
+	mux.HandleFunc("/status", g.handleStatus)
+	// The rest of the API is open to whoever reaches --listen, but views
+	// merged from /gossip drive the verdicts of every agent, so it is only
+	// served to agents that know the token.
+	if *gossipToken != "" {
+		mux.HandleFunc("/gossip", g.handleGossip)
+	}
+	gossipStop := make(chan struct{})
+	defer close(gossipStop)
+	go g.loop(d, gossipStop)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	errc := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errc <- err
+		}
+	}()
+	diag.Info("Control API listening on http://"+addr, "listen", addr)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, shutdownSignals...)
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-c:
+	case <-d.stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), daemonShutdownTimeout)
+	defer cancel()
+	srv.Shutdown(ctx)
+
+	d.mu.Lock()
+	targets = d.targets
+	d.mu.Unlock()
+	for _, t := range targets {
+		t.Stop()
+	}
+	d.wg.Wait()
+	closeSinks()
+	printReport([]RunMeta{currentRun}, targets)
+	return err
+}
+
+// add starts probing t unless a target with the same address is already
+// running, reporting whether it did.
+func (d *daemon) add(t *Target) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.find(t.Addr()) >= 0 {
+		return false
+	}
+	d.targets = append(d.targets, t)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		run(t)
+	}()
+	return true
+}
+
+// remove stops probing the target at addr and returns it.
+func (d *daemon) remove(addr string) *Target {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := d.find(addr)
+	if i < 0 {
+		return nil
+	}
+	t := d.targets[i]
+	d.targets = append(d.targets[:i], d.targets[i+1:]...)
+	t.Stop()
+	return t
+}
+
+func (d *daemon) find(addr string) int {
+	for i, t := range d.targets {
+		if t.Addr() == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+// target finds the target named by the target parameter of r, answering
+// 404 itself when there is none.
+func (d *daemon) target(w http.ResponseWriter, r *http.Request) *Target {
+	addr := r.URL.Query().Get("target")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := d.find(addr)
+	if i < 0 {
+		http.Error(w, "no such target: "+addr, http.StatusNotFound)
+		return nil
+	}
+	return d.targets[i]
+}
+
+func (d *daemon) handleTargets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		d.mu.Lock()
+		addrs := make([]string, len(d.targets))
+		for i, t := range d.targets {
+			addrs[i] = t.Addr()
+		}
+		d.mu.Unlock()
+		writeJSON(w, http.StatusOK, addrs)
+
+	case http.MethodPost:
+		var req struct {
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		targets, err := parseTargets([]string{req.Target})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !d.add(targets[0]) {
+			http.Error(w, "target already exists: "+req.Target, http.StatusConflict)
+			return
+		}
+		diag.Info("Added target "+targets[0].Addr(), "target", targets[0].Addr())
+		writeJSON(w, http.StatusCreated, targets[0].Addr())
+
+	case http.MethodDelete:
+		addr := r.URL.Query().Get("target")
+		t := d.remove(addr)
+		if t == nil {
+			http.Error(w, "no such target: "+addr, http.StatusNotFound)
+			return
+		}
+		diag.Info("Removed target "+addr, "target", addr)
+		printTargetReport(t)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *daemon) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.mu.Lock()
+	targets := append([]*Target(nil), d.targets...)
+	d.mu.Unlock()
+
+	all := make([]targetStats, len(targets))
+	for i, t := range targets {
+		all[i] = statsOf(t)
+	}
+	writeJSON(w, http.StatusOK, all)
+}
+
+func (d *daemon) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// handleMaintenance pauses or resumes probing a target, for instance while
+// it is being worked on, so that the work is not counted as an outage.
+func (d *daemon) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t := d.target(w, r)
+	if t == nil {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if !t.endMaintenance() {
+			http.Error(w, "not in maintenance: "+t.Addr(), http.StatusConflict)
+			return
+		}
+		diag.Info(fmt.Sprintf("Maintenance of %s is over", t.Addr()), "target", t.Addr())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var period time.Duration
+	if s := r.URL.Query().Get("for"); s != "" {
+		var err error
+		if period, err = time.ParseDuration(s); err != nil || period <= 0 {
+			http.Error(w, "invalid duration: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	t.startMaintenance(period)
+	if period > 0 {
+		diag.Info(fmt.Sprintf("%s is in maintenance for %s", t.Addr(), period), "target", t.Addr(), "for", period)
+	} else {
+		diag.Info(fmt.Sprintf("%s is in maintenance", t.Addr()), "target", t.Addr())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *daemon) handleResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t := d.target(w, r)
+	if t == nil {
+		return
+	}
+	ips, err := reresolve(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	diag.Info(fmt.Sprintf("Resolved %s again: %s", t.Addr(), strings.Join(ips, ", ")), "target", t.Addr(), "ips", ips)
+	writeJSON(w, http.StatusOK, ips)
+}
+
+func (d *daemon) handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t := d.target(w, r)
+	if t == nil {
+		return
+	}
+	t.Stats.reset()
+	diag.Info("Reset stats for "+t.Addr(), "target", t.Addr())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *daemon) handleBurst(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t := d.target(w, r)
+	if t == nil {
+		return
+	}
+	n := defaultBurst
+	if s := r.URL.Query().Get("count"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n <= 0 {
+			http.Error(w, "invalid count: "+s, http.StatusBadRequest)
+			return
+		}
+		if n > maxBurst {
+			http.Error(w, fmt.Sprintf("count %d is over the limit of %d", n, maxBurst), http.StatusBadRequest)
+			return
+		}
+	}
+	if t.inMaintenance() {
+		http.Error(w, "in maintenance: "+t.Addr(), http.StatusConflict)
+		return
+	}
+	diag.Info(fmt.Sprintf("Sending %d probes to %s", n, t.Addr()), "target", t.Addr(), "count", n)
+	d.burst(t, n)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func statsOf(t *Target) targetStats {
+	stats := t.Stats
+	stats.Lock()
+	defer stats.Unlock()
+
+	ts := targetStats{
+		Target:    t.Addr(),
+		Attempted: stats.Attempted,
+		Connected: stats.Connected,
+		Failed:    stats.Failed,
+		LossPct:   stats.lossPercent(),
+		MinMs:     ms(stats.MinTime),
+		AvgMs:     stats.averageTime(),
+		MaxMs:     ms(stats.MaxTime),
+		ISP:       stats.ISP,
+		LastError: stats.LastError,
+
+		Maintenance: t.inMaintenance(),
+	}
+	// Probes keep counting into stats.Failures after the lock is released
+	// and while the answer is encoded, so hand out a copy.
+	if stats.Failures != nil {
+		ts.Failures = make(map[string]int, len(stats.Failures))
+		for category, n := range stats.Failures {
+			ts.Failures[category] = n
+		}
+	}
+	if stats.Samples != nil {
+		ts.P50Ms = quantileMs(stats.Samples, 0.50)
+		ts.P95Ms = quantileMs(stats.Samples, 0.95)
+		ts.P99Ms = quantileMs(stats.Samples, 0.99)
+	}
+	return ts
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"golang.org/x/exp/slog"
+)
+
+// diag logs what happens around the probes rather than their results:
+// errors, warnings and state changes such as a target going DOWN. It
+// writes to stderr, so that probe lines and reports on stdout stay clean
+// for whatever reads them.
+var diag = slog.New(&consoleHandler{w: &diagOutput, level: &diagLevel})
+
+var (
+	diagLevel  slog.LevelVar
+	diagOutput = switchWriter{w: color.Error}
+)
+
+// errKey is the attribute that carries the error of a record, which the
+// console handler appends to the message.
+const errKey = "err"
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// setupDiag applies --log-level and, with --format json, switches diag to
+// JSON lines so that a pipeline can parse stderr as well.
+func setupDiag() error {
+	level, ok := logLevels[strings.ToLower(*logLevel)]
+	if !ok {
+		return fmt.Errorf("invalid log level %q, want debug, info, warn or error", *logLevel)
+	}
+	diagLevel.Set(level)
+	if *formatSpec == formatJSON {
+		diag = slog.New(slog.NewJSONHandler(&diagOutput, &slog.HandlerOptions{Level: &diagLevel}))
+	}
+	return nil
+}
+
+// setDiagOutput points diag at w, e.g. io.Discard while the dashboard
+// owns the terminal. diag itself stays the same logger, so this is safe
+// while probes are still logging.
+func setDiagOutput(w io.Writer) {
+	diagOutput.set(w)
+}
+
+// switchWriter is a writer whose destination can change while others
+// write to it.
+type switchWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func (s *switchWriter) set(w io.Writer) {
+	s.mu.Lock()
+	s.w = w
+	s.mu.Unlock()
+}
+
+// fatal logs err and exits with code.
+func fatal(code int, err error) {
+	diag.Error(err.Error())
+	os.Exit(code)
+}
+
+// consoleHandler prints each record as a line of plain text, red for
+// errors and yellow for warnings, followed by the error if there is one.
+// The other attributes repeat what the message says and are left to the
+// JSON handler.
+type consoleHandler struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	msg := r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == errKey {
+			msg += ": " + a.Value.String()
+		}
+		return true
+	})
+	switch {
+	case r.Level >= slog.LevelError:
+		msg = color.RedString("%s", msg)
+	case r.Level >= slog.LevelWarn:
+		msg = color.YellowString("%s", msg)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintln(h.w, msg)
+	return err
+}
+
+func (h *consoleHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *consoleHandler) WithGroup(string) slog.Handler { return h }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// diagnose takes a snapshot of the local network setup when t goes DOWN,
+// prints it and attaches it to the outage that began at start. It runs in
+// the background so the probes carry on meanwhile.
+func diagnose(t *Target, start time.Time) {
+	go func() {
+		lines := []string{
+			"Default route: " + describeRoute(),
+			"Interfaces: " + describeInterfaces(),
+			"Resolvers: " + describeResolvers(),
+			"Public IP: " + describePublicIP(),
+		}
+		t.Stats.setDiagnostics(start, lines)
+		logger.Print(color.YellowString("Diagnostics for %s:\n", t.Addr()) + " " + strings.Join(lines, "\n ") + "\n")
+	}()
+}
+
+// describeRoute names the source address the default route would use,
+// and on Linux the gateway and interface too.
+func describeRoute() string {
+	// Connecting a UDP socket only looks up the route; nothing is sent.
+	c, err := net.Dial("udp", "192.0.2.1:9")
+	if err != nil {
+		return "none (" + err.Error() + ")"
+	}
+	src := c.LocalAddr().(*net.UDPAddr).IP.String()
+	c.Close()
+	if gw, dev, ok := defaultGateway(); ok {
+		return fmt.Sprintf("via %s dev %s, source %s", gw, dev, src)
+	}
+	return "source " + src
+}
+
+// describeInterfaces lists every interface but loopback as up or down,
+// with its addresses.
+func describeInterfaces() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err.Error()
+	}
+	var parts []string
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		state := "down"
+		if ifi.Flags&net.FlagUp != 0 {
+			state = "up"
+		}
+		part := ifi.Name + " " + state
+		if addrs, err := ifi.Addrs(); err == nil && len(addrs) > 0 {
+			list := make([]string, len(addrs))
+			for i, a := range addrs {
+				list[i] = a.String()
+			}
+			part += " (" + strings.Join(list, " ") + ")"
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describeResolvers gives the --dns server, or else the name servers in
+// /etc/resolv.conf.
+func describeResolvers() string {
+	if *dnsServer != "" {
+		return *dnsServer + " (--dns)"
+	}
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "system"
+	}
+	defer f.Close()
+
+	var servers []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	if len(servers) == 0 {
+		return "none in /etc/resolv.conf"
+	}
+	return strings.Join(servers, ", ")
+}
+
+// describePublicIP asks the lookup provider for this machine's public
+// address, within --lookup-timeout.
+func describePublicIP() string {
+	if geo == nil || *lookupProvider == providerMaxMind {
+		return "not looked up (needs the ipinfo or ip-api lookup provider)"
+	}
+	info, err := geo.Lookup("")
+	if err != nil {
+		return "unknown (" + err.Error() + ")"
+	}
+	if info.IP == "" {
+		return info.Org
+	}
+	return fmt.Sprintf("%s (%s)", info.IP, info.Org)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"strings"
+)
+
+// defaultGateway reads the IPv4 default route from /proc/net/route, where
+// addresses are hex in host (little-endian) byte order.
+func defaultGateway() (string, string, bool) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", "", false
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		gw := make(net.IP, 4)
+		binary.BigEndian.PutUint32(gw, binary.LittleEndian.Uint32(b))
+		return gw.String(), fields[0], true
+	}
+	return "", "", false
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func defaultGateway() (string, string, bool) {
+	return "", "", false
+}
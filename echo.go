@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+)
+
+// runEcho answers on addr over both TCP and UDP, sending back whatever it
+// receives. It is the peer for "paping udp" and for the --keepalive and
+// --warm round trips, which need a service that replies.
+func runEcho(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	go echoTCP(ln)
+	go echoUDP(pc)
+	diag.Info(fmt.Sprintf("Echoing on %s (TCP and UDP)", addr), "listen", addr)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, shutdownSignals...)
+	<-c
+	return nil
+}
+
+func echoTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+func echoUDP(pc net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pc.WriteTo(buf[:n], from)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const edgeTimeout = 5 * time.Second
+
+// upperLayers does the TLS handshake and HTTP HEAD request on conn that
+// the --edge-* and --breakdown flags ask for, timing each into r. The
+// answering edge or PoP goes to r.Edge: the value of --edge-id-header from
+// the response when set, otherwise the common name of the TLS certificate
+// presented with --edge-tls.
+func upperLayers(conn net.Conn, host string, r *Result) error {
+	conn.SetDeadline(time.Now().Add(edgeTimeout))
+
+	var certCN string
+	if *edgeTLS || breakdownTLS {
+		cfg := &tls.Config{InsecureSkipVerify: true}
+		if !isValidIP(host) {
+			cfg.ServerName = host
+		}
+		start := time.Now()
+		tc := tls.Client(conn, cfg)
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		r.TLS = ms(time.Since(start))
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 && *edgeTLS {
+			certCN = certs[0].Subject.CommonName
+		}
+		conn = tc
+	}
+
+	if *edgeHeader == "" && !breakdownHTTP {
+		r.Edge = certCN
+		return nil
+	}
+
+	// --probe-size turns the HEAD into a GET for that many bytes of the
+	// page, to time their download as well.
+	method := http.MethodHead
+	if *probeSize > 0 && breakdownHTTP {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, "/", nil)
+	if err != nil {
+		return err
+	}
+	req.Host = host
+	req.Close = true
+	if method == http.MethodGet {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", *probeSize-1))
+	}
+	start := time.Now()
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	r.HTTP = ms(time.Since(start))
+	if method == http.MethodGet {
+		n, err := io.CopyN(io.Discard, resp.Body, int64(*probeSize))
+		if err != nil && err != io.EOF {
+			resp.Body.Close()
+			return err
+		}
+		setTransfer(r, n, time.Since(start))
+	}
+	resp.Body.Close()
+
+	r.Edge = certCN
+	if *edgeHeader != "" {
+		if edge := resp.Header.Get(*edgeHeader); edge != "" {
+			r.Edge = edge
+		}
+	}
+	return nil
+}
+
+func edgeEnabled() bool {
+	return *edgeHeader != "" || *edgeTLS
+}
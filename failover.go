@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"paping/probe"
+)
+
+// failoverPath is the target as reached from one local interface.
+type failoverPath struct {
+	iface  string
+	dialer *probe.Dialer
+	stats  *ConnectionStats
+
+	up     bool
+	lastOK time.Time
+
+	// Set by the latest round.
+	ok   bool
+	rtt  time.Duration
+	err  error
+	seen time.Time
+}
+
+// failoverEvent is one failure of the primary path: when it was last seen
+// working, when paping noticed, which backups answered at that moment and,
+// once it came back, when.
+type failoverEvent struct {
+	LastOK    time.Time
+	Detected  time.Time
+	Recovered time.Time
+	Healthy   []string
+}
+
+func (e failoverEvent) detection() time.Duration {
+	return e.Detected.Sub(e.LastOK)
+}
+
+// failoverInterfaces returns the --interfaces list, or every interface that
+// is up, not loopback and has an address.
+func failoverInterfaces() ([]string, error) {
+	if *failoverIfaces != "" {
+		return strings.Split(*failoverIfaces, ","), nil
+	}
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if addrs, err := ifi.Addrs(); err == nil && len(addrs) > 0 {
+			names = append(names, ifi.Name)
+		}
+	}
+	return names, nil
+}
+
+// runFailover probes t from every interface at once each --interval, the
+// first interface being the primary path. When the primary stops
+// answering it reports how long that took to notice and which backups
+// still answered, and prints a per-path report when done.
+func runFailover(t *Target) error {
+	names, err := failoverInterfaces()
+	if err != nil {
+		return err
+	}
+	if len(names) < 2 {
+		return fmt.Errorf("failover needs at least two interfaces, have %d; list them with --interfaces", len(names))
+	}
+
+	paths := make([]*failoverPath, len(names))
+	for i, name := range names {
+		dial, err := newDialContext(name, "", *proxyFlag)
+		if err != nil {
+			return fmt.Errorf("interface %s: %w", name, err)
+		}
+		paths[i] = &failoverPath{
+			iface:  name,
+			dialer: &probe.Dialer{DialContext: dial, Timeout: *probeTimeout},
+			stats:  &ConnectionStats{},
+			up:     true,
+		}
+	}
+	primary := paths[0]
+	logger.Printf("Probing %s from %s (primary) and %s\n", color.CyanString(t.Addr()), primary.iface, strings.Join(names[1:], ", "))
+
+	var events []failoverEvent
+	for sent := 0; !t.done(sent); sent++ {
+		if sent > 0 {
+			t.sleep(nextInterval())
+		}
+		if err := failoverRound(t, paths); err != nil {
+			diag.Error(err.Error())
+			continue
+		}
+		if !*quiet {
+			printFailoverLine(sent+1, paths)
+		}
+
+		switch {
+		case primary.up && !primary.ok:
+			e := failoverEvent{LastOK: primary.lastOK, Detected: primary.seen}
+			if e.LastOK.IsZero() {
+				e.LastOK = e.Detected
+			}
+			for _, p := range paths[1:] {
+				if p.ok {
+					e.Healthy = append(e.Healthy, p.iface)
+				}
+			}
+			events = append(events, e)
+			backups := color.RedString("no backup path answered")
+			if len(e.Healthy) > 0 {
+				backups = "still answering: " + color.GreenString(strings.Join(e.Healthy, ", "))
+			}
+			logger.Printf(color.RedString("Primary path %s failed", primary.iface)+", detected %s after its last success; %s\n", e.detection().Round(time.Millisecond), backups)
+		case !primary.up && primary.ok:
+			e := &events[len(events)-1]
+			e.Recovered = primary.seen
+			logger.Printf(color.GreenString("Primary path %s recovered", primary.iface)+" after %s\n", e.Recovered.Sub(e.LastOK).Round(time.Millisecond))
+		}
+		for _, p := range paths {
+			p.up = p.ok
+		}
+	}
+
+	printFailoverReport(t, paths, events)
+	return nil
+}
+
+// failoverRound resolves t once and probes it from every path in parallel.
+func failoverRound(t *Target, paths []*failoverPath) error {
+	ips, _, err := resolve(t.Host)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(ips[0], strconv.Itoa(t.Port))
+
+	var wg sync.WaitGroup
+	for _, p := range paths {
+		wg.Add(1)
+		go func(p *failoverPath) {
+			defer wg.Done()
+			conn, took, err := p.dialer.Dial(context.Background(), "tcp", addr)
+			p.seen = time.Now()
+			p.ok, p.rtt, p.err = err == nil, took, err
+			if err != nil {
+				p.stats.recordFailure(classify(err), err)
+				return
+			}
+			conn.Close()
+			p.lastOK = p.seen
+			p.stats.recordSuccess(took, "")
+		}(p)
+	}
+	wg.Wait()
+	return nil
+}
+
+func printFailoverLine(seq int, paths []*failoverPath) {
+	parts := make([]string, len(paths))
+	for i, p := range paths {
+		if p.ok {
+			parts[i] = p.iface + " " + color.GreenString("%s", fmtMs(ms(p.rtt)))
+		} else {
+			parts[i] = p.iface + " " + color.RedString("%s", classify(p.err))
+		}
+	}
+	if !probeLines.allow() {
+		return
+	}
+	logger.Printf("seq=%d  %s\n", seq, strings.Join(parts, "  "))
+}
+
+func printFailoverReport(t *Target, paths []*failoverPath, events []failoverEvent) {
+	probeLines.flush()
+	logger.Printf("\nFailover statistics for "+color.CyanString("%s")+":\n", t.Addr())
+	for i, p := range paths {
+		role := "backup"
+		if i == 0 {
+			role = "primary"
+		}
+		s := p.stats
+		s.Lock()
+		logger.Printf(" %-10s %-7s Attempted = "+color.CyanString("%d")+", Failed = "+color.CyanString("%d")+" ("+color.CyanString("%.2f%%")+"), Average = "+color.CyanString("%s")+"\n",
+			p.iface, role, s.Attempted, s.Failed, s.lossPercent(), fmtMs(s.averageTime()))
+		s.Unlock()
+	}
+	if len(events) == 0 {
+		logger.Printf("Primary path %s never failed\n", paths[0].iface)
+		return
+	}
+	logger.Printf("Primary failures = "+color.CyanString("%d")+":\n", len(events))
+	for _, e := range events {
+		healthy := color.RedString("none")
+		if len(e.Healthy) > 0 {
+			healthy = color.GreenString(strings.Join(e.Healthy, ", "))
+		}
+		down := "still down"
+		if !e.Recovered.IsZero() {
+			down = "down " + e.Recovered.Sub(e.LastOK).Round(time.Millisecond).String()
+		}
+		logger.Printf(" %s  detected in "+color.CyanString("%s")+", %s, healthy backups: %s\n",
+			e.Detected.Format("2006-01-02 15:04:05"), e.detection().Round(time.Millisecond), down, healthy)
+	}
+}
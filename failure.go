@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Failure categories, counted separately in the stats.
+const (
+	failTimeout     = "timeout"
+	failRefused     = "refused"
+	failUnreachable = "unreachable"
+	failDNS         = "dns"
+	failDropped     = "dropped"
+	failOpen        = "open"
+	failProtocol    = "protocol"
+	failOther       = "error"
+)
+
+var failCategories = []string{failTimeout, failRefused, failUnreachable, failDNS, failDropped, failOpen, failProtocol, failOther}
+
+// classify sorts a probe error into one of the failure categories.
+func classify(err error) string {
+	var (
+		dropErr  *dropError
+		protoErr *protocolError
+		dnsErr   *net.DNSError
+		errno    syscall.Errno
+		netErr   net.Error
+	)
+	switch {
+	case errors.As(err, &dropErr):
+		return failDropped
+	case errors.As(err, &protoErr):
+		return failProtocol
+	case errors.As(err, &dnsErr):
+		return failDNS
+	case errors.Is(err, errPortOpen):
+		return failOpen
+	case errors.As(err, &errno) && isRefused(errno):
+		return failRefused
+	case errors.As(err, &errno) && isUnreachable(errno):
+		return failUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return failTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return failTimeout
+	}
+	return failOther
+}
+
+// failureMessage is the text printed for a failed probe in the normal
+// layout.
+func failureMessage(category string, err error) string {
+	switch category {
+	case failTimeout:
+		return "Connection timed out"
+	case failRefused:
+		return "Connection refused"
+	case failUnreachable:
+		return "Network unreachable"
+	case failDNS:
+		return "DNS lookup failed: " + err.Error()
+	case failDropped:
+		var dropErr *dropError
+		errors.As(err, &dropErr)
+		return "Connection dropped: " + dropErr.err.Error()
+	case failOpen:
+		return "Port is open"
+	case failProtocol:
+		return "Protocol check failed: " + err.Error()
+	}
+	return "Connection failed: " + err.Error()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"dropped", &dropError{io.EOF}, failDropped},
+		{"protocol", &protocolError{"smtp", errors.New("421 busy")}, failProtocol},
+		{"protocol timeout", &protocolError{"redis", os.ErrDeadlineExceeded}, failProtocol},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "invalid.test"}}, failDNS},
+		{"open", errPortOpen, failOpen},
+		{"context deadline", fmt.Errorf("dial: %w", context.DeadlineExceeded), failTimeout},
+		{"socket deadline", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, failTimeout},
+		{"other", errors.New("something else"), failOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.err); got != tt.want {
+				t.Errorf("classify(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, err = net.DialTimeout("tcp", addr, time.Second)
+	if err == nil {
+		t.Skip("closed port accepted a connection")
+	}
+	if got := classify(err); got != failRefused {
+		t.Errorf("classify(%v) = %q, want %q", err, got, failRefused)
+	}
+}
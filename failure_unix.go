@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+func isRefused(errno syscall.Errno) bool {
+	return errno == syscall.ECONNREFUSED
+}
+
+func isUnreachable(errno syscall.Errno) bool {
+	return errno == syscall.EHOSTUNREACH || errno == syscall.ENETUNREACH
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+func isRefused(errno syscall.Errno) bool {
+	return errno == windows.WSAECONNREFUSED
+}
+
+func isUnreachable(errno syscall.Errno) bool {
+	return errno == windows.WSAEHOSTUNREACH || errno == windows.WSAENETUNREACH
+}
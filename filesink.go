@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotateTimeFormat = "20060102T150405.000"
+
+var csvHeader = []string{"time", "target", "ip", "proto", "seq", "success", "rtt_ms", "dns_ms", "isp", "category", "error"}
+
+// sizeFlag is a byte count such as "500MB", "500MiB" or "2g"; K, M and G
+// are powers of 1024 with or without a B or iB after them, in any case,
+// and a bare number is bytes.
+type sizeFlag int64
+
+func (s *sizeFlag) String() string {
+	if *s == 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *sizeFlag) Set(v string) error {
+	num := strings.ToUpper(strings.TrimSpace(v))
+	iec := strings.HasSuffix(num, "IB")
+	if iec {
+		num = num[:len(num)-2]
+	} else {
+		num = strings.TrimSuffix(num, "B")
+	}
+	mult := int64(1)
+	if num != "" {
+		switch num[len(num)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || iec && mult == 1 {
+		return fmt.Errorf("invalid size %q", v)
+	}
+	*s = sizeFlag(n * mult)
+	return nil
+}
+
+// rotatingFile appends to path, moving it aside once it grows past
+// maxSize or gets older than every. Rotated files are gzipped in the
+// background, and the oldest ones are deleted once they are older than
+// retain or together take more than retainSize.
+type rotatingFile struct {
+	path       string
+	header     []byte
+	maxSize    int64
+	every      time.Duration
+	retain     time.Duration
+	retainSize int64
+
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+
+	archive sync.WaitGroup
+	pruneMu sync.Mutex
+}
+
+func newRotatingFile(path string, header []byte) (*rotatingFile, error) {
+	if *rotateEvery < 0 || *retainAge < 0 {
+		return nil, errors.New("--rotate-every and --retain must not be negative")
+	}
+	rf := &rotatingFile{
+		path:       path,
+		header:     header,
+		maxSize:    int64(rotateSize),
+		every:      *rotateEvery,
+		retain:     *retainAge,
+		retainSize: int64(retainSize),
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.w, rf.size, rf.opened = f, bufio.NewWriter(f), info.Size(), time.Now()
+	if rf.size == 0 && len(rf.header) > 0 {
+		return rf.write(rf.header)
+	}
+	return nil
+}
+
+// append writes one record, rotating first if it is due.
+func (rf *rotatingFile) append(p []byte) error {
+	full := rf.maxSize > 0 && rf.size > int64(len(rf.header)) && rf.size+int64(len(p)) > rf.maxSize
+	old := rf.every > 0 && time.Since(rf.opened) >= rf.every
+	if full || old {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	return rf.write(p)
+}
+
+func (rf *rotatingFile) write(p []byte) error {
+	n, err := rf.w.Write(p)
+	rf.size += int64(n)
+	return err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(rf.path)
+	rotated := strings.TrimSuffix(rf.path, ext) + "-" + time.Now().Format(rotateTimeFormat) + ext
+	if err := os.Rename(rf.path, rotated); err != nil {
+		return err
+	}
+	rf.archive.Add(1)
+	go func() {
+		defer rf.archive.Done()
+		if err := gzipFile(rotated); err != nil {
+			diag.Error("Sink", errKey, err)
+		}
+		rf.prune()
+	}()
+	return rf.open()
+}
+
+func (rf *rotatingFile) flush() error {
+	return rf.w.Flush()
+}
+
+func (rf *rotatingFile) close() error {
+	err := rf.w.Flush()
+	if cerr := rf.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// closeAndWait closes the file and waits for rotated files to be archived.
+func (rf *rotatingFile) closeAndWait() error {
+	err := rf.close()
+	rf.archive.Wait()
+	return err
+}
+
+// prune deletes rotated files past the --retain age, then the oldest ones
+// until the rest fit in --retain-size. The live file is never touched.
+func (rf *rotatingFile) prune() {
+	if rf.retain == 0 && rf.retainSize == 0 {
+		return
+	}
+	rf.pruneMu.Lock()
+	defer rf.pruneMu.Unlock()
+
+	ext := filepath.Ext(rf.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(rf.path, ext) + "-*" + ext + ".gz")
+	if err != nil {
+		return
+	}
+	// The timestamp in the name sorts oldest first.
+	sort.Strings(matches)
+
+	type archived struct {
+		path string
+		size int64
+	}
+	var kept []archived
+	var total int64
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		if rf.retain > 0 && time.Since(info.ModTime()) > rf.retain {
+			os.Remove(m)
+			continue
+		}
+		kept = append(kept, archived{m, info.Size()})
+		total += info.Size()
+	}
+	for i := 0; rf.retainSize > 0 && total > rf.retainSize && i < len(kept); i++ {
+		os.Remove(kept[i].path)
+		total -= kept[i].size
+	}
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compress %s: %w", path, err)
+	}
+	src.Close()
+	return os.Remove(path)
+}
+
+// fileSink writes every result as a line to a file, in JSON or CSV,
+// rotating and archiving it per the --rotate-* and --retain* flags.
+type fileSink struct {
+	format func(Result) ([]byte, error)
+
+	mu sync.Mutex
+	rf *rotatingFile
+}
+
+func newJSONFileSink(path string) (*fileSink, error) {
+	rf, err := newRotatingFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("json: %w", err)
+	}
+	return &fileSink{rf: rf, format: func(r Result) ([]byte, error) {
+		data, err := json.Marshal(r)
+		return append(data, '\n'), err
+	}}, nil
+}
+
+func newCSVFileSink(path string) (*fileSink, error) {
+	rf, err := newRotatingFile(path, csvLine(csvHeader))
+	if err != nil {
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+	return &fileSink{rf: rf, format: func(r Result) ([]byte, error) {
+		return csvLine(csvRecord(r)), nil
+	}}, nil
+}
+
+func csvLine(fields []string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(fields)
+	w.Flush()
+	return buf.Bytes()
+}
+
+func (s *fileSink) Write(r Result) error {
+	line, err := s.format(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rf.append(line); err != nil {
+		return fmt.Errorf("%s: %w", s.rf.path, err)
+	}
+	return nil
+}
+
+func (s *fileSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rf.flush()
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rf.closeAndWait()
+}
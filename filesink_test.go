@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestSizeFlag(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"4096", 4096, true},
+		{"512B", 512, true},
+		{"100K", 100 << 10, true},
+		{"100MB", 100 << 20, true},
+		{"100MiB", 100 << 20, true},
+		{"100mib", 100 << 20, true},
+		{"2gb", 2 << 30, true},
+		{"2GiB", 2 << 30, true},
+		{"0", 0, true},
+		{"5iB", 0, false},
+		{"-1M", 0, false},
+		{"1T", 0, false},
+		{"MB", 0, false},
+	}
+	for _, tt := range tests {
+		var s sizeFlag
+		err := s.Set(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("Set(%q) error = %v, want ok = %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && int64(s) != tt.want {
+			t.Errorf("Set(%q) = %d, want %d", tt.in, int64(s), tt.want)
+		}
+	}
+}
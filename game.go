@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// gamePeriod is how long a stretch of the run --game rates on its own when
+// looking for the worst periods.
+const gamePeriod = 10 * time.Second
+
+// gameWorst is how many of the worst periods --game lists.
+const gameWorst = 3
+
+// gameLimits are the round trip, jitter and loss that competitive online
+// games tolerate: within good nobody notices, within fair casual play is
+// fine but fast shooters and fighting games feel it, beyond that it lags.
+var gameLimits = struct {
+	pingGood, pingFair     time.Duration
+	jitterGood, jitterFair time.Duration
+	lossGood, lossFair     float64
+}{
+	pingGood: 50 * time.Millisecond, pingFair: 100 * time.Millisecond,
+	jitterGood: 10 * time.Millisecond, jitterFair: 30 * time.Millisecond,
+	lossGood: 1, lossFair: 3,
+}
+
+// gameRating orders how well a measure suits games, best first.
+type gameRating int
+
+const (
+	gameGood gameRating = iota
+	gameFair
+	gameBad
+)
+
+func rateGame(v, good, fair float64) gameRating {
+	switch {
+	case v <= good:
+		return gameGood
+	case v <= fair:
+		return gameFair
+	}
+	return gameBad
+}
+
+// gameWindow sums the probes of one gamePeriod. Jitter is the mean
+// difference between consecutive round trips.
+type gameWindow struct {
+	Start     time.Time
+	Probes    int
+	Lost      int
+	Total     time.Duration
+	jitterSum time.Duration
+	jitterN   int
+}
+
+func (w gameWindow) ping() time.Duration {
+	if w.Probes == w.Lost {
+		return 0
+	}
+	return w.Total / time.Duration(w.Probes-w.Lost)
+}
+
+func (w gameWindow) jitter() time.Duration {
+	if w.jitterN == 0 {
+		return 0
+	}
+	return w.jitterSum / time.Duration(w.jitterN)
+}
+
+func (w gameWindow) loss() float64 {
+	return float64(w.Lost) / float64(w.Probes) * 100
+}
+
+// badness is how far the window is past the good limits, by the measure
+// furthest past them; up to 1 is good.
+func (w gameWindow) badness() float64 {
+	b := float64(w.ping()) / float64(gameLimits.pingGood)
+	if j := float64(w.jitter()) / float64(gameLimits.jitterGood); j > b {
+		b = j
+	}
+	if l := w.loss() / gameLimits.lossGood; l > b {
+		b = l
+	}
+	return b
+}
+
+// gameTracker splits a target's results into gamePeriod windows for
+// --game.
+type gameTracker struct {
+	windows []gameWindow
+	last    time.Duration
+}
+
+func (g *gameTracker) observe(r Result) {
+	start := r.Time.Truncate(gamePeriod)
+	// Concurrent probes can finish slightly out of order, so the window
+	// may be one before the latest.
+	i := len(g.windows) - 1
+	for i >= 0 && g.windows[i].Start.After(start) {
+		i--
+	}
+	if i < 0 || !g.windows[i].Start.Equal(start) {
+		i++
+		g.windows = append(g.windows, gameWindow{})
+		copy(g.windows[i+1:], g.windows[i:])
+		g.windows[i] = gameWindow{Start: start}
+	}
+
+	w := &g.windows[i]
+	w.Probes++
+	if !r.Success {
+		w.Lost++
+		return
+	}
+	rtt := fromMs(r.RTT)
+	w.Total += rtt
+	if g.last > 0 {
+		diff := rtt - g.last
+		if diff < 0 {
+			diff = -diff
+		}
+		w.jitterSum += diff
+		w.jitterN++
+	}
+	g.last = rtt
+}
+
+// total sums every window into one.
+func (g *gameTracker) total() gameWindow {
+	var all gameWindow
+	for _, w := range g.windows {
+		all.Probes += w.Probes
+		all.Lost += w.Lost
+		all.Total += w.Total
+		all.jitterSum += w.jitterSum
+		all.jitterN += w.jitterN
+	}
+	return all
+}
+
+// worst returns up to n windows that were not good for games, worst first.
+func (g *gameTracker) worst(n int) []gameWindow {
+	var bad []gameWindow
+	for _, w := range g.windows {
+		if w.badness() > 1 {
+			bad = append(bad, w)
+		}
+	}
+	sort.SliceStable(bad, func(i, j int) bool { return bad[i].badness() > bad[j].badness() })
+	if len(bad) > n {
+		bad = bad[:n]
+	}
+	return bad
+}
+
+// printGame tells in plain words how the run would feel in an online game,
+// measure by measure, and when it was at its worst.
+func printGame(g *gameTracker) {
+	all := g.total()
+	if all.Probes == 0 {
+		return
+	}
+	if all.Probes == all.Lost {
+		logger.Printf("Gaming verdict: %s\n", color.RedString("unplayable, nothing got through"))
+		return
+	}
+
+	ping := rateGame(float64(all.ping()), float64(gameLimits.pingGood), float64(gameLimits.pingFair))
+	jitter := rateGame(float64(all.jitter()), float64(gameLimits.jitterGood), float64(gameLimits.jitterFair))
+	loss := rateGame(all.loss(), gameLimits.lossGood, gameLimits.lossFair)
+	overall := ping
+	if jitter > overall {
+		overall = jitter
+	}
+	if loss > overall {
+		overall = loss
+	}
+
+	switch overall {
+	case gameGood:
+		logger.Printf("Gaming verdict: %s\n", color.GreenString("good for competitive play"))
+	case gameFair:
+		logger.Printf("Gaming verdict: %s\n", color.YellowString("fine for casual play, noticeable in fast-paced games"))
+	default:
+		logger.Printf("Gaming verdict: %s\n", color.RedString("expect lag"))
+	}
+	logger.Printf(" Ping "+color.CyanString("%.0fms")+": %s\n", ms(all.ping()), [...]string{
+		"low enough that nobody has an edge over you",
+		fmt.Sprintf("playable, but over %s you react later than players nearby", gameLimits.pingGood),
+		fmt.Sprintf("over %s, shots and moves visibly land late", gameLimits.pingFair),
+	}[ping])
+	logger.Printf(" Jitter "+color.CyanString("%.0fms")+": %s\n", ms(all.jitter()), [...]string{
+		"steady, the game can smooth it out",
+		fmt.Sprintf("over %s, aim and hit registration can feel inconsistent", gameLimits.jitterGood),
+		fmt.Sprintf("over %s, expect rubber-banding and players teleporting", gameLimits.jitterFair),
+	}[jitter])
+	logger.Printf(" Loss "+color.CyanString("%.1f%%")+": %s\n", all.loss(), [...]string{
+		"hardly any, nothing goes missing",
+		fmt.Sprintf("over %g%%, now and then an action does not register", gameLimits.lossGood),
+		fmt.Sprintf("over %g%%, actions get dropped and the game stutters", gameLimits.lossFair),
+	}[loss])
+
+	worst := g.worst(gameWorst)
+	if len(worst) == 0 {
+		return
+	}
+	logger.Printf(" Worst periods:\n")
+	for _, w := range worst {
+		var issues []string
+		if w.Probes == w.Lost {
+			issues = append(issues, "no connection")
+		} else {
+			if w.ping() > gameLimits.pingGood {
+				issues = append(issues, fmt.Sprintf("ping %.0fms", ms(w.ping())))
+			}
+			if w.jitter() > gameLimits.jitterGood {
+				issues = append(issues, fmt.Sprintf("jitter %.0fms", ms(w.jitter())))
+			}
+			if w.loss() > gameLimits.lossGood {
+				issues = append(issues, fmt.Sprintf("%.0f%% loss", w.loss()))
+			}
+		}
+		logger.Printf("  %s-%s  %s\n", w.Start.Format("15:04:05"), w.Start.Add(gamePeriod).Format("15:04:05"), color.YellowString(strings.Join(issues, ", ")))
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	providerIPInfo  = "ipinfo"
+	providerIPAPI   = "ip-api"
+	providerMaxMind = "maxmind"
+)
+
+type IPInfo struct {
+	// IP is the address looked up, given back by the providers that
+	// can look up the machine's own.
+	IP  string `json:"ip,omitempty"`
+	Org string `json:"org"`
+	// ASN is the autonomous system number, such as "AS15169", and
+	// Country the two-letter country code, when the provider knows them.
+	ASN     string `json:"asn,omitempty"`
+	Country string `json:"country,omitempty"`
+	// Loc is the approximate location as "latitude,longitude", when the
+	// provider knows it.
+	Loc string `json:"loc,omitempty"`
+}
+
+// GeoLookup finds out who operates an IP address. An empty ip asks about
+// the public address the lookup itself comes from.
+type GeoLookup interface {
+	Lookup(ip string) (*IPInfo, error)
+}
+
+// --info-fields: which parts of the IP info probe lines show.
+const (
+	infoOrg     = "org"
+	infoASN     = "asn"
+	infoCountry = "country"
+)
+
+var infoFields map[string]bool
+
+func parseInfoFields(spec string) error {
+	infoFields = make(map[string]bool)
+	for _, f := range strings.Split(spec, ",") {
+		switch f = strings.TrimSpace(f); f {
+		case infoOrg, infoASN, infoCountry:
+			infoFields[f] = true
+		default:
+			return fmt.Errorf("invalid --info-fields entry %q, want org, asn or country", f)
+		}
+	}
+	return nil
+}
+
+// asnOf picks the AS number off the front of an organization such as
+// "AS15169 Google LLC", the form ipinfo and ip-api give it in.
+func asnOf(org string) string {
+	if as, _, _ := strings.Cut(org, " "); strings.HasPrefix(as, "AS") {
+		return as
+	}
+	return ""
+}
+
+// newGeoLookup builds the lookup selected by flags, wrapped in a cache.
+// It returns nil when lookups are disabled.
+func newGeoLookup() (GeoLookup, error) {
+	if err := parseInfoFields(*infoFieldSpec); err != nil {
+		return nil, err
+	}
+	if *noLookup {
+		return nil, nil
+	}
+
+	var provider GeoLookup
+	switch *lookupProvider {
+	case providerIPInfo:
+		provider = &ipinfoLookup{token: *lookupToken}
+	case providerIPAPI:
+		provider = &ipAPILookup{token: *lookupToken}
+	case providerMaxMind:
+		if *maxmindDB == "" {
+			return nil, errors.New("--lookup-provider maxmind needs --maxmind-db")
+		}
+		db, err := maxminddb.Open(*maxmindDB)
+		if err != nil {
+			return nil, err
+		}
+		provider = &maxmindLookup{db: db}
+	default:
+		return nil, fmt.Errorf("invalid lookup provider %q, want ipinfo, ip-api or maxmind", *lookupProvider)
+	}
+
+	lookupClient.Timeout = *lookupTimeout
+	if *lookupFailures > 0 {
+		provider = &breakerLookup{next: provider, threshold: *lookupFailures, cooldown: lookupCooldown}
+	}
+	return newCachedLookup(provider, *lookupTTL, *lookupCache)
+}
+
+// lookupClient makes the lookup providers' requests. It goes through the
+// proxy named by HTTPS_PROXY, HTTP_PROXY and NO_PROXY, like most tools,
+// and its timeout keeps a broken network from holding a probe forever.
+var lookupClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+func getJSON(rawURL string, v any) error {
+	resp, err := lookupClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", resp.Request.URL.Host, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type ipinfoLookup struct {
+	token string
+}
+
+func (l *ipinfoLookup) Lookup(ip string) (*IPInfo, error) {
+	path := ip + "/json"
+	if ip == "" {
+		path = "json"
+	}
+	rawURL := "http://ipinfo.io/" + path
+	if l.token != "" {
+		rawURL = fmt.Sprintf("https://ipinfo.io/%s?token=%s", path, url.QueryEscape(l.token))
+	}
+
+	var resp struct {
+		IP      string `json:"ip"`
+		Org     string `json:"org"`
+		Loc     string `json:"loc"`
+		Country string `json:"country"`
+	}
+	if err := getJSON(rawURL, &resp); err != nil {
+		return nil, err
+	}
+	return &IPInfo{IP: resp.IP, Org: resp.Org, Loc: resp.Loc, ASN: asnOf(resp.Org), Country: resp.Country}, nil
+}
+
+type ipAPILookup struct {
+	token string
+}
+
+func (l *ipAPILookup) Lookup(ip string) (*IPInfo, error) {
+	rawURL := fmt.Sprintf("http://ip-api.com/json/%s?fields=status,message,query,as,countryCode,lat,lon", ip)
+	if l.token != "" {
+		rawURL = fmt.Sprintf("https://pro.ip-api.com/json/%s?fields=status,message,query,as,countryCode,lat,lon&key=%s", ip, url.QueryEscape(l.token))
+	}
+
+	var resp struct {
+		Status  string  `json:"status"`
+		Message string  `json:"message"`
+		Query   string  `json:"query"`
+		AS      string  `json:"as"`
+		Country string  `json:"countryCode"`
+		Lat     float64 `json:"lat"`
+		Lon     float64 `json:"lon"`
+	}
+	if err := getJSON(rawURL, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("ip-api: %s", resp.Message)
+	}
+	return &IPInfo{IP: resp.Query, Org: resp.AS, ASN: asnOf(resp.AS), Country: resp.Country, Loc: fmt.Sprintf("%g,%g", resp.Lat, resp.Lon)}, nil
+}
+
+// maxmindLookup reads a local GeoLite2-ASN (or compatible) database, so
+// no network requests are made at all. That database has no locations,
+// nor can it tell the machine's own public address.
+type maxmindLookup struct {
+	db *maxminddb.Reader
+}
+
+func (l *maxmindLookup) Lookup(ip string) (*IPInfo, error) {
+	var rec struct {
+		Number uint   `maxminddb:"autonomous_system_number"`
+		Org    string `maxminddb:"autonomous_system_organization"`
+	}
+	if err := l.db.Lookup(net.ParseIP(ip), &rec); err != nil {
+		return nil, err
+	}
+	if rec.Number == 0 {
+		return &IPInfo{}, nil
+	}
+	asn := fmt.Sprintf("AS%d", rec.Number)
+	return &IPInfo{Org: asn + " " + rec.Org, ASN: asn}, nil
+}
+
+// lookupCooldown is how long lookups stay off once --lookup-max-failures
+// have failed in a row.
+const lookupCooldown = time.Minute
+
+// errLookupDisabled is returned while the breaker is open. Like any failed
+// lookup it leaves the probe without an ISP, but it is not warned about on
+// every probe.
+var errLookupDisabled = errors.New("ISP lookups disabled after repeated failures")
+
+// breakerLookup stops asking the provider for a while after threshold
+// lookups in a row have failed, then lets one through to see whether it
+// has recovered.
+type breakerLookup struct {
+	next      GeoLookup
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breakerLookup) Lookup(ip string) (*IPInfo, error) {
+	b.mu.Lock()
+	if time.Now().Before(b.openUntil) {
+		b.mu.Unlock()
+		return nil, errLookupDisabled
+	}
+	b.mu.Unlock()
+
+	info, err := b.next.Lookup(ip)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return info, nil
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		diag.Warn(fmt.Sprintf("ISP lookups failed %d times in a row (%v); skipping them for %s", b.failures, err, b.cooldown), "failures", b.failures, "cooldown", b.cooldown)
+		b.failures = 0
+	}
+	return nil, err
+}
+
+type cacheEntry struct {
+	Info    IPInfo    `json:"info"`
+	Expires time.Time `json:"expires"`
+}
+
+// cachedLookup remembers answers for ttl, optionally persisting them to a
+// JSON file so they survive restarts. The machine's own address is never
+// cached, since it is asked about to see whether it has changed.
+type cachedLookup struct {
+	next GeoLookup
+	ttl  time.Duration
+	path string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newCachedLookup(next GeoLookup, ttl time.Duration, path string) (*cachedLookup, error) {
+	c := &cachedLookup{next: next, ttl: ttl, path: path, entries: make(map[string]cacheEntry)}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("lookup cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *cachedLookup) Lookup(ip string) (*IPInfo, error) {
+	if ip == "" {
+		return c.next.Lookup(ip)
+	}
+	c.mu.Lock()
+	e, ok := c.entries[ip]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.Expires) {
+		info := e.Info
+		return &info, nil
+	}
+
+	diag.Debug("Looking up the ISP of "+ip, "ip", ip)
+	info, err := c.next.Lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[ip] = cacheEntry{Info: *info, Expires: time.Now().Add(c.ttl)}
+	if c.path != "" {
+		c.save()
+	}
+	return info, nil
+}
+
+// forget drops the answer remembered for ip.
+func (c *cachedLookup) forget(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[ip]; !ok {
+		return
+	}
+	delete(c.entries, ip)
+	if c.path != "" {
+		c.save()
+	}
+}
+
+func (c *cachedLookup) save() {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err == nil {
+		os.Rename(tmp, c.path)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthView is one agent's opinion of one target at a point in time.
+type healthView struct {
+	Agent   string    `json:"agent"`
+	Target  string    `json:"target"`
+	Up      bool      `json:"up"`
+	LossPct float64   `json:"loss_pct"`
+	Updated time.Time `json:"updated"`
+	Stale   bool      `json:"stale,omitempty"`
+
+	// received is when this agent got the view. Staleness is judged by
+	// it rather than by Updated, which comes from the clock of another
+	// machine.
+	received time.Time
+}
+
+// gossip shares target health between serve agents so any of them can tell
+// whether a target is down everywhere or only from some vantage points.
+// Every --gossip-interval an agent refreshes its own views and exchanges
+// everything it knows with one random peer (push-pull); both sides keep
+// the newest view per agent and target, so views spread to agents that
+// are not peered directly. Agents prove they belong to the same group
+// with the --gossip-token they share.
+type gossip struct {
+	self   string
+	peers  []string
+	token  string
+	client *http.Client
+
+	mu    sync.Mutex
+	views map[string]healthView
+}
+
+func newGossip(self string, peers []string, token string) *gossip {
+	return &gossip{
+		self:   self,
+		peers:  peers,
+		token:  token,
+		client: &http.Client{Timeout: *gossipInterval},
+		views:  make(map[string]healthView),
+	}
+}
+
+// peerList splits --peers into base URLs, adding http:// where missing.
+func peerList(spec string) []string {
+	var list []string
+	for _, p := range strings.Split(spec, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !strings.Contains(p, "://") {
+			p = "http://" + p
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+// loop keeps the views of d's targets fresh and gossips them until stop
+// is closed.
+func (g *gossip) loop(d *daemon, stop <-chan struct{}) {
+	ticker := time.NewTicker(*gossipInterval)
+	defer ticker.Stop()
+	for {
+		g.refresh(d)
+		if len(g.peers) > 0 {
+			g.exchange(g.peers[rand.Intn(len(g.peers))])
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh records this agent's current view of each target it probes.
+func (g *gossip) refresh(d *daemon) {
+	d.mu.Lock()
+	targets := append([]*Target(nil), d.targets...)
+	d.mu.Unlock()
+
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, v := range g.views {
+		if v.Agent == g.self {
+			delete(g.views, key)
+		}
+	}
+	for _, t := range targets {
+		t.Stats.Lock()
+		n := len(t.Stats.History)
+		if n > 0 {
+			v := healthView{Agent: g.self, Target: t.Addr(), Up: t.Stats.History[n-1] >= 0, LossPct: t.Stats.lossPercent(), Updated: now, received: now}
+			g.views[v.Agent+"|"+v.Target] = v
+		}
+		t.Stats.Unlock()
+	}
+}
+
+func (g *gossip) all() []healthView {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	views := make([]healthView, 0, len(g.views))
+	for _, v := range g.views {
+		views = append(views, v)
+	}
+	return views
+}
+
+// merge keeps the newer of each incoming view and the one already known.
+func (g *gossip) merge(views []healthView) {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, v := range views {
+		if v.Agent == g.self {
+			continue
+		}
+		key := v.Agent + "|" + v.Target
+		if old, ok := g.views[key]; !ok || v.Updated.After(old.Updated) {
+			v.Stale = false
+			v.received = now
+			g.views[key] = v
+		}
+	}
+}
+
+// exchange sends every known view to peer and merges what it sends back.
+func (g *gossip) exchange(peer string) {
+	body, err := json.Marshal(g.all())
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(peer, "/")+"/gossip", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var views []healthView
+	if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&views) == nil {
+		g.merge(views)
+	}
+}
+
+func (g *gossip) handleGossip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	want := "Bearer " + g.token
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+		http.Error(w, "wrong or missing gossip token", http.StatusUnauthorized)
+		return
+	}
+	var views []healthView
+	if err := json.NewDecoder(r.Body).Decode(&views); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply := g.all()
+	g.merge(views)
+	writeJSON(w, http.StatusOK, reply)
+}
+
+type targetStatus struct {
+	Target  string       `json:"target"`
+	Verdict string       `json:"verdict"`
+	Up      int          `json:"up"`
+	Down    int          `json:"down"`
+	Views   []healthView `json:"views"`
+}
+
+// handleStatus answers "is it down everywhere or just from here?" for the
+// target given as ?target=host:port, or for every known target.
+func (g *gossip) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	want := r.URL.Query().Get("target")
+
+	// Views not refreshed for a few rounds come from agents that have
+	// stopped or become unreachable; show them but leave them out of the
+	// verdict. Their age is counted from when they reached this agent, so
+	// clock skew between agents does not matter.
+	staleAfter := time.Now().Add(-3 * *gossipInterval)
+	byTarget := make(map[string]*targetStatus)
+	for _, v := range g.all() {
+		if want != "" && v.Target != want {
+			continue
+		}
+		ts := byTarget[v.Target]
+		if ts == nil {
+			ts = &targetStatus{Target: v.Target}
+			byTarget[v.Target] = ts
+		}
+		v.Stale = v.received.Before(staleAfter)
+		switch {
+		case v.Stale:
+		case v.Up:
+			ts.Up++
+		default:
+			ts.Down++
+		}
+		ts.Views = append(ts.Views, v)
+	}
+	if want != "" && byTarget[want] == nil {
+		http.Error(w, "no agent reports on "+want, http.StatusNotFound)
+		return
+	}
+
+	all := make([]*targetStatus, 0, len(byTarget))
+	for _, ts := range byTarget {
+		switch {
+		case ts.Up+ts.Down == 0:
+			ts.Verdict = "unknown"
+		case ts.Down == 0:
+			ts.Verdict = "up everywhere"
+		case ts.Up == 0:
+			ts.Verdict = "down everywhere"
+		default:
+			ts.Verdict = "down from some agents"
+		}
+		sort.Slice(ts.Views, func(i, j int) bool { return ts.Views[i].Agent < ts.Views[j].Agent })
+		all = append(all, ts)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Target < all[j].Target })
+	if want != "" {
+		writeJSON(w, http.StatusOK, all[0])
+		return
+	}
+	writeJSON(w, http.StatusOK, all)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// synProbe sends a bare SYN to ip:port from a raw socket and times the
+// SYN-ACK, never completing the handshake: the kernel resets it since no
+// socket of its own sent the SYN. This is the network round trip alone,
+// without the wait for the connection to be accepted. It needs root or
+// CAP_NET_RAW.
+func synProbe(local net.Addr, ipStr string, port int, timeout time.Duration) (time.Duration, error) {
+	dst := net.ParseIP(ipStr)
+	if dst == nil {
+		return 0, fmt.Errorf("invalid IP %q", ipStr)
+	}
+	src, err := synSource(local, dst, port)
+	if err != nil {
+		return 0, err
+	}
+	network := "ip4:tcp"
+	if dst.To4() == nil {
+		network = "ip6:tcp"
+	}
+	lc := net.ListenConfig{Control: markingControl()}
+	c, err := lc.ListenPacket(context.Background(), network, src.String())
+	if err != nil {
+		return 0, fmt.Errorf("half-open probes need a raw socket (root or CAP_NET_RAW): %w", err)
+	}
+	defer c.Close()
+
+	var rnd [6]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		return 0, err
+	}
+	sport := 32768 + binary.BigEndian.Uint16(rnd[:2])%28232
+	seq := binary.BigEndian.Uint32(rnd[2:])
+
+	syn := make([]byte, 20)
+	binary.BigEndian.PutUint16(syn[0:], sport)
+	binary.BigEndian.PutUint16(syn[2:], uint16(port))
+	binary.BigEndian.PutUint32(syn[4:], seq)
+	syn[12] = 5 << 4
+	syn[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(syn[14:], 65535)
+	binary.BigEndian.PutUint16(syn[16:], tcpChecksum(src, dst, syn))
+
+	c.SetDeadline(time.Now().Add(timeout))
+	start := time.Now()
+	if _, err := c.WriteTo(syn, &net.IPAddr{IP: dst}); err != nil {
+		return 0, err
+	}
+
+	// The socket sees every TCP segment for this host, so wait for the
+	// one answering our SYN. IPv4 headers are stripped by the net package.
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := c.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		if n < 20 || !from.(*net.IPAddr).IP.Equal(dst) {
+			continue
+		}
+		seg := buf[:n]
+		if binary.BigEndian.Uint16(seg[0:]) != uint16(port) || binary.BigEndian.Uint16(seg[2:]) != sport ||
+			binary.BigEndian.Uint32(seg[8:]) != seq+1 {
+			continue
+		}
+		switch flags := seg[13]; {
+		case flags&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN|tcpFlagACK:
+			return time.Since(start), nil
+		case flags&tcpFlagRST != 0:
+			return 0, &net.OpError{Op: "syn", Net: "tcp", Addr: &net.TCPAddr{IP: dst, Port: port}, Err: syscall.ECONNREFUSED}
+		}
+	}
+}
+
+// synSource returns the local address a SYN to dst goes out from: the
+// --interface address if set, otherwise the one the routing table picks.
+func synSource(local net.Addr, dst net.IP, port int) (net.IP, error) {
+	if tcp, ok := local.(*net.TCPAddr); ok {
+		return tcp.IP, nil
+	}
+	// Connecting a UDP socket only looks up the route; nothing is sent.
+	c, err := net.Dial("udp", net.JoinHostPort(dst.String(), fmt.Sprint(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	src := c.LocalAddr().(*net.UDPAddr).IP
+	if src == nil {
+		return nil, errors.New("no route to " + dst.String())
+	}
+	return src, nil
+}
+
+// tcpChecksum computes the checksum of a TCP segment over the IPv4 or
+// IPv6 pseudo-header.
+func tcpChecksum(src, dst net.IP, seg []byte) uint16 {
+	var pseudo []byte
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		pseudo = append(append(pseudo, src4...), dst4...)
+		pseudo = append(pseudo, 0, syscall.IPPROTO_TCP)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(seg)))
+	} else {
+		pseudo = append(append(pseudo, src.To16()...), dst.To16()...)
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(seg)))
+		pseudo = append(pseudo, 0, 0, 0, syscall.IPPROTO_TCP)
+	}
+
+	var sum uint32
+	data := append(pseudo, seg...)
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A history block is sealed and compressed once it holds historyBlockSize
+// probes or spans historyBlockSpan, whichever comes first; retention drops
+// whole blocks.
+const (
+	historyBlockSize = 1024
+	historyBlockSpan = 10 * time.Minute
+)
+
+// historyMaxPoints is the most raw probes GET /history returns; longer
+// ranges need a step.
+const historyMaxPoints = 10000
+
+// historyPoint is one probe: its time in Unix nanoseconds and its round
+// trip in microseconds, or -1 when it failed.
+type historyPoint struct {
+	t   int64
+	rtt int64
+}
+
+// historyBlock holds the sealed probes from first to last, as varint
+// deltas of the time followed by the round trip, deflated.
+type historyBlock struct {
+	first, last int64
+	count       int
+	data        []byte
+}
+
+func sealBlock(points []historyPoint) historyBlock {
+	var raw []byte
+	prev := int64(0)
+	for _, p := range points {
+		raw = binary.AppendVarint(raw, p.t-prev)
+		raw = binary.AppendVarint(raw, p.rtt)
+		prev = p.t
+	}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write(raw)
+	w.Close()
+	return historyBlock{first: points[0].t, last: points[len(points)-1].t, count: len(points), data: buf.Bytes()}
+}
+
+func (b historyBlock) points() []historyPoint {
+	raw, _ := io.ReadAll(flate.NewReader(bytes.NewReader(b.data)))
+	points := make([]historyPoint, 0, b.count)
+	r := bytes.NewReader(raw)
+	prev := int64(0)
+	for {
+		d, err := binary.ReadVarint(r)
+		if err != nil {
+			break
+		}
+		rtt, err := binary.ReadVarint(r)
+		if err != nil {
+			break
+		}
+		prev += d
+		points = append(points, historyPoint{prev, rtt})
+	}
+	return points
+}
+
+// targetHistory is the retained probes of one target: sealed blocks,
+// oldest first, and the block being filled.
+type targetHistory struct {
+	blocks []historyBlock
+	open   []historyPoint
+}
+
+// history keeps the results of every target the daemon probes for
+// --history, so that GET /history can answer for a time range without an
+// external database. It is registered as a sink.
+type history struct {
+	mu      sync.Mutex
+	retain  time.Duration
+	targets map[string]*targetHistory
+}
+
+func newHistory(retain time.Duration) *history {
+	return &history{retain: retain, targets: make(map[string]*targetHistory)}
+}
+
+func (h *history) Write(r Result) error {
+	p := historyPoint{t: r.Time.UnixNano(), rtt: -1}
+	if r.Success {
+		p.rtt = fromMs(r.RTT).Microseconds()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	th := h.targets[r.Target]
+	if th == nil {
+		th = &targetHistory{}
+		h.targets[r.Target] = th
+	}
+	th.open = append(th.open, p)
+	if len(th.open) >= historyBlockSize || time.Duration(p.t-th.open[0].t) >= historyBlockSpan {
+		th.blocks = append(th.blocks, sealBlock(th.open))
+		th.open = nil
+		h.prune(th, r.Time)
+	}
+	return nil
+}
+
+func (h *history) Flush() error { return nil }
+
+func (h *history) Close() error { return nil }
+
+// prune drops the blocks of th that ended before the retention period.
+func (h *history) prune(th *targetHistory, now time.Time) {
+	cutoff := now.Add(-h.retain).UnixNano()
+	i := 0
+	for i < len(th.blocks) && th.blocks[i].last < cutoff {
+		i++
+	}
+	th.blocks = th.blocks[i:]
+}
+
+// query returns the probes of target from from to to, oldest first, and
+// whether there is any history for target at all.
+func (h *history) query(target string, from, to time.Time) ([]historyPoint, bool) {
+	h.mu.Lock()
+	th := h.targets[target]
+	if th == nil {
+		h.mu.Unlock()
+		return nil, false
+	}
+	h.prune(th, time.Now())
+	var blocks []historyBlock
+	for _, b := range th.blocks {
+		if b.last >= from.UnixNano() && b.first <= to.UnixNano() {
+			blocks = append(blocks, b)
+		}
+	}
+	open := append([]historyPoint(nil), th.open...)
+	h.mu.Unlock()
+
+	var points []historyPoint
+	for _, b := range blocks {
+		points = append(points, b.points()...)
+	}
+	points = append(points, open...)
+	i := 0
+	for _, p := range points {
+		if p.t >= from.UnixNano() && p.t <= to.UnixNano() {
+			points[i] = p
+			i++
+		}
+	}
+	return points[:i], true
+}
+
+type historyProbe struct {
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	RTT     float64   `json:"rtt_ms,omitempty"`
+}
+
+// historyBucket summarizes the probes of one step.
+type historyBucket struct {
+	Time    time.Time `json:"time"`
+	Probes  int       `json:"probes"`
+	Failed  int       `json:"failed"`
+	LossPct float64   `json:"loss_pct"`
+	MinMs   float64   `json:"min_ms,omitempty"`
+	AvgMs   float64   `json:"avg_ms,omitempty"`
+	MaxMs   float64   `json:"max_ms,omitempty"`
+}
+
+// downsample sums points into buckets of step, aligned to multiples of
+// step, leaving out the steps without probes.
+func downsample(points []historyPoint, step time.Duration) []historyBucket {
+	var buckets []historyBucket
+	var total time.Duration
+	finish := func() {
+		b := &buckets[len(buckets)-1]
+		b.LossPct = float64(b.Failed) / float64(b.Probes) * 100
+		if ok := b.Probes - b.Failed; ok > 0 {
+			b.AvgMs = ms(total / time.Duration(ok))
+		}
+	}
+	for _, p := range points {
+		start := time.Unix(0, p.t).Truncate(step)
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Time.Equal(start) {
+			if len(buckets) > 0 {
+				finish()
+			}
+			buckets = append(buckets, historyBucket{Time: start})
+			total = 0
+		}
+		b := &buckets[len(buckets)-1]
+		b.Probes++
+		if p.rtt < 0 {
+			b.Failed++
+			continue
+		}
+		rtt := time.Duration(p.rtt) * time.Microsecond
+		total += rtt
+		if b.MinMs == 0 || ms(rtt) < b.MinMs {
+			b.MinMs = ms(rtt)
+		}
+		if ms(rtt) > b.MaxMs {
+			b.MaxMs = ms(rtt)
+		}
+	}
+	if len(buckets) > 0 {
+		finish()
+	}
+	return buckets
+}
+
+// parseHistoryTime reads a from or to parameter: an RFC 3339 time, a
+// duration before now such as 6h, or def when empty.
+func parseHistoryTime(s string, now, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want RFC 3339 or a duration before now such as 6h", s)
+	}
+	return t, nil
+}
+
+// handleHistory answers GET /history?target=h:p[&from=6h][&to=...][&step=1m]
+// with the retained probes of a target, or with them summed per step.
+// from defaults to the start of the history and to to now.
+func (h *history) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	from, err := parseHistoryTime(q.Get("from"), now, now.Add(-h.retain))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseHistoryTime(q.Get("to"), now, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if s := q.Get("step"); s != "" {
+		if step, err = time.ParseDuration(s); err != nil || step <= 0 {
+			http.Error(w, "invalid step: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+
+	target := q.Get("target")
+	points, ok := h.query(target, from, to)
+	if !ok {
+		http.Error(w, "no history for target: "+target, http.StatusNotFound)
+		return
+	}
+	if step > 0 {
+		writeJSON(w, http.StatusOK, downsample(points, step))
+		return
+	}
+	if len(points) > historyMaxPoints {
+		http.Error(w, "more than "+strconv.Itoa(historyMaxPoints)+" probes in range; narrow it or give a step", http.StatusBadRequest)
+		return
+	}
+	probes := make([]historyProbe, len(points))
+	for i, p := range points {
+		probes[i] = historyProbe{Time: time.Unix(0, p.t), Success: p.rtt >= 0}
+		if p.rtt >= 0 {
+			probes[i].RTT = ms(time.Duration(p.rtt) * time.Microsecond)
+		}
+	}
+	writeJSON(w, http.StatusOK, probes)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoryBlockRoundTrip(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano()
+	var points []historyPoint
+	for i := 0; i < historyBlockSize; i++ {
+		rtt := int64(1500 + i%7*100)
+		if i%50 == 0 {
+			rtt = -1
+		}
+		points = append(points, historyPoint{start + int64(i)*int64(time.Second) + int64(i%3), rtt})
+	}
+
+	b := sealBlock(points)
+	if b.first != points[0].t || b.last != points[len(points)-1].t || b.count != len(points) {
+		t.Errorf("block spans %d-%d with %d points, want %d-%d with %d", b.first, b.last, b.count, points[0].t, points[len(points)-1].t, len(points))
+	}
+	if got := b.points(); !reflect.DeepEqual(got, points) {
+		t.Errorf("decoded %d points that differ from the %d sealed", len(got), len(points))
+	}
+	// Deltas a second apart should pack far below 16 bytes per point.
+	if len(b.data) > len(points)*4 {
+		t.Errorf("block takes %d bytes for %d points", len(b.data), len(points))
+	}
+}
+
+func TestHistoryBlockSinglePoint(t *testing.T) {
+	p := []historyPoint{{time.Now().UnixNano(), -1}}
+	if got := sealBlock(p).points(); !reflect.DeepEqual(got, p) {
+		t.Errorf("decoded %v, want %v", got, p)
+	}
+}
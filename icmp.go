@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var icmpSeq atomic.Uint32
+
+// listenICMP opens an ICMP socket for ip's family, preferring the
+// unprivileged datagram kind Linux and macOS allow (see
+// net.ipv4.ping_group_range) and falling back to a raw socket.
+func listenICMP(ip net.IP) (*icmp.PacketConn, bool, error) {
+	network, raw := "udp4", "ip4:icmp"
+	if ip.To4() == nil {
+		network, raw = "udp6", "ip6:ipv6-icmp"
+	}
+	if c, err := icmp.ListenPacket(network, ""); err == nil {
+		return c, true, nil
+	}
+	c, err := icmp.ListenPacket(raw, "")
+	if err != nil {
+		return nil, false, fmt.Errorf("ICMP needs a raw socket (root or CAP_NET_RAW) or an allowed net.ipv4.ping_group_range: %w", err)
+	}
+	return c, false, nil
+}
+
+// icmpEcho sends one echo request to ip and waits up to timeout for the
+// matching reply, returning the round trip.
+func icmpEcho(ipStr string, timeout time.Duration) (time.Duration, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return 0, fmt.Errorf("invalid IP %q", ipStr)
+	}
+	c, datagram, err := listenICMP(ip)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	var typ, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	proto := 1
+	if ip.To4() == nil {
+		typ, reply, proto = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply, 58
+	}
+	seq := int(icmpSeq.Add(1) & 0xffff)
+	payload := []byte(fmt.Sprintf("paping %d %d", os.Getpid(), time.Now().UnixNano()))
+	msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: payload}}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var dst net.Addr = &net.IPAddr{IP: ip}
+	if datagram {
+		dst = &net.UDPAddr{IP: ip}
+	}
+	c.SetDeadline(time.Now().Add(timeout))
+	start := time.Now()
+	if _, err := c.WriteTo(data, dst); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		m, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || m.Type != reply {
+			continue
+		}
+		// Datagram sockets rewrite the ID, so match on the payload.
+		if echo, ok := m.Body.(*icmp.Echo); ok && echo.Seq == seq && bytes.Equal(echo.Data, payload) {
+			return time.Since(start), nil
+		}
+	}
+}
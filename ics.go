@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// icsSink writes every outage it sees as an event in an iCalendar file,
+// rewriting the whole file each time an outage ends so it is always valid.
+type icsSink struct {
+	path string
+
+	mu       sync.Mutex
+	trackers map[string]*outageTracker
+	outages  []Outage
+}
+
+func newICSSink(path string) *icsSink {
+	return &icsSink{path: path, trackers: make(map[string]*outageTracker)}
+}
+
+func (s *icsSink) Write(r Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tr := s.trackers[r.Target]
+	if tr == nil {
+		tr = &outageTracker{}
+		s.trackers[r.Target] = tr
+	}
+	if o := tr.observe(r); o != nil {
+		s.outages = append(s.outages, *o)
+		return s.save()
+	}
+	return nil
+}
+
+func (s *icsSink) Flush() error {
+	return nil
+}
+
+// Close ends any outage still in progress and writes the final calendar.
+func (s *icsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, tr := range s.trackers {
+		if o := tr.close(now); o != nil {
+			s.outages = append(s.outages, *o)
+		}
+	}
+	return s.save()
+}
+
+func (s *icsSink) save() error {
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(icsCalendar(s.outages)), 0o644); err != nil {
+		return fmt.Errorf("ICS: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("ICS: %w", err)
+	}
+	return nil
+}
+
+func icsCalendar(outages []Outage) string {
+	var b strings.Builder
+	line := func(s string) {
+		b.WriteString(icsFold(s))
+		b.WriteString("\r\n")
+	}
+
+	stamp := time.Now().UTC().Format(icsTimeFormat)
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//paping//outages//EN")
+	for _, o := range outages {
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:%d-%s@paping", o.Start.UnixNano(), strings.ReplaceAll(o.Target, ":", "-")))
+		line("DTSTAMP:" + stamp)
+		line("DTSTART:" + o.Start.UTC().Format(icsTimeFormat))
+		line("DTEND:" + o.End.UTC().Format(icsTimeFormat))
+		line("SUMMARY:" + icsEscape(fmt.Sprintf("Outage: %s (%s)", o.Target, o.Duration().Round(time.Second))))
+		line("DESCRIPTION:" + icsEscape(fmt.Sprintf("%d failed probes. Last error: %s", o.Failed, o.LastError)))
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icsFold splits content lines longer than 75 octets as RFC 5545 requires,
+// without breaking UTF-8 sequences.
+func icsFold(s string) string {
+	const limit = 75
+	if len(s) <= limit {
+		return s
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > limit {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
@@ -0,0 +1,14 @@
+package main
+
+import "time"
+
+// ISPChange is a point during a --resolve-each run where the target's
+// address moved to a different ISP or ASN, as after a DNS hijack or a CDN
+// switching providers.
+type ISPChange struct {
+	Time   time.Time
+	From   string
+	To     string
+	FromIP string
+	ToIP   string
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runJobs probes every "host port [proto]" line read from in once and
+// writes each result to out as a JSON line, until in is exhausted.
+func runJobs(in io.Reader, out io.Writer) {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		enc = json.NewEncoder(out)
+	)
+	write := func(r Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(r)
+	}
+
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		t, err := parseJob(line)
+		if err != nil {
+			write(Result{Time: time.Now(), Target: line, Error: err.Error()})
+			continue
+		}
+
+		acquireSlot()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer releaseSlot()
+			write(ping(t))
+		}()
+	}
+	wg.Wait()
+}
+
+func parseJob(line string) (*Target, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("want \"host port [proto]\", got %q", line)
+	}
+	targets, err := parseTargets([]string{net.JoinHostPort(fields[0], fields[1])})
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 3 {
+		if err := checkProto(fields[2]); err != nil {
+			return nil, err
+		}
+		if isUnixNet(fields[2]) {
+			return nil, fmt.Errorf("jobs take a host and port, not a %s socket", fields[2])
+		}
+		targets[0].Proto = fields[2]
+	}
+	return targets[0], nil
+}
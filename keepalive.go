@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+const keepaliveTimeout = 5 * time.Second
+
+// dropError marks a probe that found the persistent --keepalive connection
+// closed or reset, typically by a middlebox expiring it while idle.
+type dropError struct {
+	err error
+}
+
+func (e *dropError) Error() string { return "connection dropped: " + e.err.Error() }
+func (e *dropError) Unwrap() error { return e.err }
+
+// runKeepalive holds one connection to t open and measures round trips on
+// it, reconnecting only after it drops. The first probe of each connection
+// reports the connect time; later ones the time for a 1-byte write to be
+// answered, which needs a service that echoes or otherwise responds. With
+// --probe-size they send that many bytes instead and time the echo, which
+// needs a service that echoes all of them, such as paping echo.
+func runKeepalive(t *Target) {
+	var (
+		conn    net.Conn
+		ip, isp string
+	)
+	for sent := 0; !t.done(sent); sent++ {
+		if sent > 0 {
+			t.sleep(nextInterval())
+		}
+		if !t.limit.wait(t.ctx) {
+			break
+		}
+		acquireSlot()
+		r := newResult(t)
+		var err error
+		if conn == nil {
+			conn, err = connect(t, &r)
+			ip, isp = r.IP, r.ISP
+			// As in ping, a dial aborted by stopping t is not a result.
+			if errors.Is(err, context.Canceled) && t.ctx.Err() != nil {
+				releaseSlot()
+				break
+			}
+		} else {
+			r.Reused = true
+			r.IP, r.ISP = ip, isp
+			var rtt, took time.Duration
+			if *probeSize > 0 {
+				rtt, took, err = echoTransfer(conn, *probeSize)
+			} else {
+				rtt, err = roundTrip(conn)
+			}
+			if err == nil {
+				r.RTT = ms(rtt)
+				setTransfer(&r, int64(*probeSize), took)
+			} else {
+				conn.Close()
+				conn = nil
+				t.Stats.recordDrop()
+				err = &dropError{err}
+			}
+		}
+		finish(t, r, err)
+		releaseSlot()
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// drainWait is how long drain waits for more leftover bytes.
+const drainWait = time.Millisecond
+
+// drain discards whatever conn has already received, such as the rest of
+// an answer longer than one read or a late echo, so that it is not taken
+// for the answer to the next probe. A service that never stops sending
+// is drained for at most keepaliveTimeout.
+func drain(conn net.Conn, buf []byte) error {
+	defer conn.SetReadDeadline(time.Time{})
+	end := time.Now().Add(keepaliveTimeout)
+	for time.Now().Before(end) {
+		conn.SetReadDeadline(time.Now().Add(drainWait))
+		if _, err := conn.Read(buf); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// roundTrip writes a single byte to conn and times how long the service
+// takes to send anything back.
+func roundTrip(conn net.Conn) (time.Duration, error) {
+	buf := make([]byte, 4096)
+	if err := drain(conn, buf); err != nil {
+		return 0, err
+	}
+	conn.SetDeadline(time.Now().Add(keepaliveTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	start := time.Now()
+	if _, err := conn.Write([]byte{'\n'}); err != nil {
+		return 0, err
+	}
+	n, err := conn.Read(buf)
+	if n == 0 && err == nil {
+		err = errors.New("empty read")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
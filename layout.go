@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+const (
+	layoutAuto    = "auto"
+	layoutCompact = "compact"
+	layoutNormal  = "normal"
+	layoutWide    = "wide"
+)
+
+// --show levels: which probe lines reach the console. Sinks always get
+// every result.
+const (
+	showAll      = "all"
+	showFailures = "failures"
+	showChanges  = "changes"
+)
+
+const (
+	compactMaxWidth = 60
+	wideMinWidth    = 120
+)
+
+// segment is one piece of a probe line; plain is used to measure it and
+// colored is what gets printed.
+type segment struct {
+	plain   string
+	colored string
+}
+
+func checkLayout(name string) error {
+	switch name {
+	case layoutAuto, layoutCompact, layoutNormal, layoutWide:
+		return nil
+	}
+	return fmt.Errorf("invalid layout %q, want auto, compact, normal or wide", name)
+}
+
+// terminalWidth returns the width of stdout, or 0 when it is not a terminal.
+func terminalWidth() int {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+func currentLayout() (string, int) {
+	width := terminalWidth()
+	if *layoutName != layoutAuto {
+		return *layoutName, width
+	}
+	switch {
+	case width == 0:
+		return layoutNormal, 0
+	case width < compactMaxWidth:
+		return layoutCompact, width
+	case width >= wideMinWidth:
+		return layoutWide, width
+	}
+	return layoutNormal, width
+}
+
+func checkVerbosity() error {
+	switch *showLevel {
+	case showAll, showFailures, showChanges:
+	default:
+		return fmt.Errorf("invalid --show %q, want failures, changes or all", *showLevel)
+	}
+	if *onlyFailures {
+		if *showLevel == showChanges {
+			return errors.New("--only-failures cannot be combined with --show changes")
+		}
+		*showLevel = showFailures
+	}
+	if *quiet && (*verbose || *showLevel != showAll) {
+		return errors.New("-q cannot be combined with -v, --show or --only-failures")
+	}
+	if *maxLinesPerSec < 0 {
+		return errors.New("--max-lines-per-sec must not be negative")
+	}
+	return nil
+}
+
+// printResult prints the line for one probe through the --format
+// formatter, honoring -q, --show and --max-lines-per-sec. With --show
+// changes no probe lines are printed, only the UP and DOWN transitions
+// and other change notices.
+func printResult(r Result, err error) {
+	if *quiet || *showLevel == showChanges || (*showLevel == showFailures && r.Success && !r.Spike) || !probeLines.allow() {
+		return
+	}
+	if machineFormat() {
+		resultLog.Print(formatter.Format(r, err))
+		return
+	}
+	logger.Print(formatter.Format(r, err))
+}
+
+func normalSegments(r Result, err error) []segment {
+	if !r.Success {
+		msg := failureMessage(r.Category, err)
+		segs := []segment{{msg, color.RedString("%s", msg)}, kv("category", r.Category)}
+		if r.Overload {
+			segs = append(segs, loadSegments(r)...)
+		}
+		if *verbose {
+			segs = append(segs, verboseSegments(r)...)
+		}
+		return segs
+	}
+
+	host := r.Host
+	var addrs []string
+	if r.IP != "" && r.IP != r.Host {
+		addrs = append(addrs, r.IP)
+	}
+	if r.RDNS != "" && r.RDNS != r.Host {
+		addrs = append(addrs, r.RDNS)
+	}
+	if len(addrs) > 0 {
+		host = fmt.Sprintf("%s (%s)", r.Host, strings.Join(addrs, ", "))
+	}
+	verb := "Connected to "
+	switch {
+	case r.Reused:
+		verb = "Reply from "
+	case r.HalfOpen:
+		verb = "SYN-ACK from "
+	case r.Closed == failRefused:
+		verb = "Refused by "
+	case r.Closed == failTimeout:
+		verb = "Timed out on "
+	case r.Closed == failUnreachable:
+		verb = "No route to "
+	}
+	segs := []segment{
+		{verb + host, verb + color.GreenString("%s", host)},
+	}
+	if r.DNS > 0 {
+		segs = append(segs, kv("dns", fmtMs(r.DNS)))
+	}
+	if len(r.ProxyLegs) > 0 {
+		legs := make([]string, len(r.ProxyLegs))
+		for i, leg := range r.ProxyLegs {
+			legs[i] = fmtMs(leg)
+		}
+		segs = append(segs, kv("legs", strings.Join(legs, "+")))
+	}
+	if breakdownEnabled() {
+		segs = append(segs, kv(phaseTCP, fmtMs(r.RTT)))
+		segs = append(segs, phaseSegments(r)...)
+	} else {
+		segs = append(segs, kv("time", fmtMs(r.RTT)))
+	}
+	segs = append(segs, transferSegments(r)...)
+	if r.Spike {
+		segs = append(segs, segment{"spike", color.YellowString("spike")}, kv("baseline", fmtMs(r.Baseline)))
+	}
+	if r.Overload {
+		segs = append(segs, loadSegments(r)...)
+	}
+	segs = append(segs, timingSegments(r)...)
+	if r.Attempts > 1 {
+		segs = append(segs, kv("attempt", fmt.Sprint(r.Attempts)))
+	}
+	segs = append(segs, kv("protocol", strings.ToUpper(r.Proto)))
+	if r.Port > 0 {
+		segs = append(segs, kv("port", fmt.Sprint(r.Port)))
+	}
+	if r.ALPN != "" {
+		segs = append(segs, kv("alpn", r.ALPN))
+	}
+	if r.ISP != "" && infoFields[infoOrg] {
+		segs = append(segs, kv("ISP", r.ISP))
+	}
+	if r.ASN != "" {
+		segs = append(segs, kv("ASN", r.ASN))
+	}
+	if r.Country != "" {
+		segs = append(segs, kv("country", r.Country))
+	}
+	if r.Edge != "" {
+		segs = append(segs, kv("edge", r.Edge))
+	}
+	if *verbose {
+		segs = append(segs, verboseSegments(r)...)
+	}
+	return segs
+}
+
+// phaseSegments time the layers --breakdown adds after the TCP connect.
+func phaseSegments(r Result) []segment {
+	var segs []segment
+	if r.TLS > 0 {
+		segs = append(segs, kv(phaseTLS, fmtMs(r.TLS)))
+	}
+	if r.HTTP > 0 {
+		segs = append(segs, kv(phaseHTTP, fmtMs(r.HTTP)))
+	}
+	return segs
+}
+
+// timingSegments add the --user-perceived and --warm times to the
+// connection time.
+func timingSegments(r Result) []segment {
+	var segs []segment
+	if r.Perceived > 0 {
+		segs = append(segs, kv("perceived", fmtMs(r.Perceived)))
+	}
+	if r.WarmRTT > 0 {
+		segs = append(segs, kv("first", fmtMs(r.FirstRTT)), kv("warm", fmtMs(r.WarmRTT)))
+	}
+	return segs
+}
+
+// extraSegments are what compact and wide lines show after their fixed
+// columns: how --expect-closed saw the port turned away, and the times
+// and transfer added by other flags.
+func extraSegments(r Result) []segment {
+	var segs []segment
+	if r.Closed != "" {
+		segs = append(segs, kv("closed", r.Closed))
+	}
+	segs = append(segs, timingSegments(r)...)
+	segs = append(segs, phaseSegments(r)...)
+	return append(segs, transferSegments(r)...)
+}
+
+// transferSegments show what --probe-size moved and how fast.
+func transferSegments(r Result) []segment {
+	if r.Bytes == 0 {
+		return nil
+	}
+	return []segment{kv("bytes", fmtCount(int(r.Bytes))), kv("rate", fmtBitrate(r.Bitrate))}
+}
+
+// loadSegments flag a probe taken while this machine was overloaded.
+func loadSegments(r Result) []segment {
+	segs := []segment{{"overloaded", color.YellowString("overloaded")}, kv("cpu", fmt.Sprintf("%.0f%%", r.CPU))}
+	if r.NICDrops > 0 {
+		segs = append(segs, kv("nic-drops", fmtCount(int(r.NICDrops))))
+	}
+	return segs
+}
+
+// verboseSegments describes the socket and name resolution behind r for -v.
+func verboseSegments(r Result) []segment {
+	var segs []segment
+	if r.Local != "" {
+		segs = append(segs, kv("local", r.Local))
+	}
+	if r.SRTT > 0 {
+		segs = append(segs, kv("srtt", fmtMs(r.SRTT)), kv("rttvar", fmtMs(r.RTTVar)), kv("retrans", fmt.Sprint(r.Retrans)))
+	}
+	if r.IP != "" {
+		segs = append(segs, kv("remote", net.JoinHostPort(r.IP, fmt.Sprint(r.Port))))
+	}
+	if len(r.Resolved) > 0 {
+		segs = append(segs, kv("resolved", strings.Join(r.Resolved, ",")), kv("resolver", resolverName()))
+	}
+	return segs
+}
+
+// wrapSegments joins segments with spaces, breaking between segments rather
+// than letting the terminal split words when the line exceeds width.
+func wrapSegments(segs []segment, width int) string {
+	var b strings.Builder
+	col := 0
+	for i, s := range segs {
+		if i > 0 {
+			if width > 0 && col+1+len(s.plain) > width {
+				b.WriteString("\n  ")
+				col = 2
+			} else {
+				b.WriteByte(' ')
+				col++
+			}
+		}
+		b.WriteString(s.colored)
+		col += len(s.plain)
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// kv is a key=value segment with the value highlighted.
+func kv(key, value string) segment {
+	return segment{key + "=" + value, key + "=" + color.GreenString("%s", value)}
+}
+
+// joinSegments prints segs after the fixed columns of the compact and wide
+// layouts, each preceded by sep.
+func joinSegments(segs []segment, sep string) string {
+	var b strings.Builder
+	for _, s := range segs {
+		b.WriteString(sep)
+		b.WriteString(s.colored)
+	}
+	return b.String()
+}
+
+func compactLine(r Result) string {
+	var details string
+	if *verbose {
+		details = joinSegments(verboseSegments(r), " ")
+	}
+	if !r.Success {
+		return fmt.Sprintf("%s %s #%d %s%s\n", color.RedString("✗"), r.Target, r.Seq, color.RedString(r.Category), details)
+	}
+	extra := joinSegments(extraSegments(r), " ") + details
+	if r.Spike {
+		return fmt.Sprintf("%s %s #%d %s%s\n", color.YellowString("!"), r.Target, r.Seq, color.YellowString("%s", fmtMs(r.RTT)), extra)
+	}
+	return fmt.Sprintf("%s %s #%d %s%s\n", color.GreenString("✓"), r.Target, r.Seq, color.GreenString("%s", fmtMs(r.RTT)), extra)
+}
+
+func wideLine(r Result) string {
+	ts := r.Time.Format("15:04:05.000")
+	var details string
+	if *verbose {
+		details = joinSegments(verboseSegments(r), "  ")
+	}
+	if !r.Success {
+		return fmt.Sprintf("%s  %-28s  seq=%-6d %s%s\n", ts, r.Target, r.Seq, color.RedString("%-10s  %s", r.Category, r.Error), details)
+	}
+	dns := "-"
+	if r.DNS > 0 {
+		dns = fmtMs(r.DNS)
+	}
+	var info []string
+	if r.ISP != "" && infoFields[infoOrg] {
+		info = append(info, r.ISP)
+	}
+	for _, v := range []string{r.ASN, r.Country, r.RDNS} {
+		if v != "" {
+			info = append(info, v)
+		}
+	}
+	line := fmt.Sprintf("%s  %-28s  seq=%-6d %s  dns=%-9s %-4s %-15s  %s", ts, r.Target, r.Seq, color.GreenString("%10s", fmtMs(r.RTT)), dns, strings.ToUpper(r.Proto), r.IP, strings.Join(info, "  "))
+	line += joinSegments(extraSegments(r), "  ")
+	if r.Edge != "" {
+		line += "  edge=" + r.Edge
+	}
+	if r.Spike {
+		line += color.YellowString("  spike baseline=%s", fmtMs(r.Baseline))
+	}
+	return line + details + "\n"
+}
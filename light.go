@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+const (
+	earthRadiusKm = 6371
+	// fibreKmPerMs is how far light gets in optical fibre, at about two
+	// thirds of its speed in a vacuum, in one millisecond.
+	fibreKmPerMs = 200
+)
+
+// locateTargets looks up where this machine's public address and each
+// target are for --speed-of-light, recording the distance between them in
+// the target's stats. A target that cannot be placed is reported and left
+// without the annotation.
+func locateTargets(targets []*Target) {
+	self, err := geo.Lookup("")
+	if err != nil {
+		diag.Warn(fmt.Sprintf("Speed of light: cannot locate this machine: %v", err))
+		return
+	}
+	lat, lon, err := parseLoc(self.Loc)
+	if err != nil {
+		diag.Warn(fmt.Sprintf("Speed of light: cannot locate this machine: %v", err))
+		return
+	}
+	for _, t := range targets {
+		km, err := targetDistance(t, lat, lon)
+		if err != nil {
+			diag.Warn(fmt.Sprintf("Speed of light: cannot locate %s: %v", t.Addr(), err), "target", t.Addr())
+			continue
+		}
+		t.Stats.Lock()
+		t.Stats.DistanceKm = km
+		t.Stats.Unlock()
+	}
+}
+
+func targetDistance(t *Target, lat, lon float64) (float64, error) {
+	ips, _, err := resolve(t.Host)
+	if err != nil {
+		return 0, err
+	}
+	info, err := geo.Lookup(ips[0])
+	if err != nil {
+		return 0, err
+	}
+	tlat, tlon, err := parseLoc(info.Loc)
+	if err != nil {
+		return 0, err
+	}
+	return greatCircleKm(lat, lon, tlat, tlon), nil
+}
+
+// parseLoc parses a "latitude,longitude" location.
+func parseLoc(loc string) (float64, float64, error) {
+	latStr, lonStr, ok := strings.Cut(loc, ",")
+	if !ok {
+		return 0, 0, errors.New("no location known")
+	}
+	lat, err := strconv.ParseFloat(latStr, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid location %q", loc)
+	}
+	lon, err := strconv.ParseFloat(lonStr, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid location %q", loc)
+	}
+	return lat, lon, nil
+}
+
+// greatCircleKm returns the haversine distance between two points.
+func greatCircleKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dlat := (lat2 - lat1) * rad
+	dlon := (lon2 - lon1) * rad
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// printSpeedOfLight puts the measured average next to the fastest round
+// trip physics allows over the straight-line distance. Real paths are
+// longer and add queueing, so a factor of 2 or so is normal.
+func printSpeedOfLight(km, avgMs float64) {
+	minMs := 2 * km / fibreKmPerMs
+	logger.Printf("Speed of light:\n")
+	line := " Distance = " + color.CyanString("%s km", groupDigits(fmt.Sprintf("%.0f", km))) + ", Minimum round trip in fibre = " + color.CyanString("%s", fmtMs(minMs))
+	if minMs > 0 {
+		line += ", Measured = " + color.CyanString("%.1fx", avgMs/minMs)
+	}
+	logger.Println(line)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// loadPeriod is how often --sample-load reads the CPU and NIC counters. A
+// probe is judged by the last period that ended before it finished.
+const loadPeriod = 250 * time.Millisecond
+
+// overloadCPU is the CPU use, in percent of all cores, from which this
+// machine counts as overloaded: probes can then wait for the scheduler as
+// long as for the network.
+const overloadCPU = 90.0
+
+// loadCounters are the cumulative CPU and NIC counters of this machine.
+type loadCounters struct {
+	cpuBusy, cpuTotal uint64
+	drops             uint64
+}
+
+// loadSample is the CPU use and the packets the NICs dropped over one
+// loadPeriod.
+type loadSample struct {
+	CPU   float64
+	Drops uint64
+}
+
+func (l loadSample) overloaded() bool {
+	return l.CPU >= overloadCPU || l.Drops > 0
+}
+
+var (
+	loadMu   sync.Mutex
+	lastLoad loadSample
+)
+
+// startLoadSampler reads the counters once to check they are available and
+// then every loadPeriod for the rest of the run.
+func startLoadSampler() error {
+	prev, err := readLoadCounters()
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(loadPeriod) {
+			cur, err := readLoadCounters()
+			if err != nil {
+				continue
+			}
+			var s loadSample
+			if total := cur.cpuTotal - prev.cpuTotal; total > 0 {
+				s.CPU = float64(cur.cpuBusy-prev.cpuBusy) / float64(total) * 100
+			}
+			if cur.drops > prev.drops {
+				s.Drops = cur.drops - prev.drops
+			}
+			prev = cur
+
+			loadMu.Lock()
+			lastLoad = s
+			loadMu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// markLoad records the latest load sample on r.
+func markLoad(r *Result) {
+	loadMu.Lock()
+	s := lastLoad
+	loadMu.Unlock()
+	r.CPU, r.NICDrops, r.Overload = s.CPU, s.Drops, s.overloaded()
+}
+
+// printLoad compares the probes taken while this machine was overloaded
+// with the rest, so that a slow stretch caused here is not blamed on the
+// network.
+func printLoad(s *ConnectionStats) {
+	if s.Overloaded == 0 {
+		logger.Printf("Local load: never overloaded (CPU under %.0f%%, no NIC drops)\n", overloadCPU)
+		return
+	}
+	logger.Printf("Local load: "+color.YellowString("%s")+" probes while this machine was overloaded (CPU %.0f%%+ or NIC drops), "+color.CyanString("%s")+" of them failed\n",
+		fmtCount(s.Overloaded), overloadCPU, fmtCount(s.Overloaded-s.OverloadedConnected))
+	rest := s.Connected - s.OverloadedConnected
+	if s.OverloadedConnected > 0 && rest > 0 {
+		logger.Printf(" Average "+color.CyanString("%s")+" while overloaded, "+color.CyanString("%s")+" otherwise\n",
+			fmtMs(ms(s.OverloadedTotal/time.Duration(s.OverloadedConnected))), fmtMs(ms((s.TotalTime-s.OverloadedTotal)/time.Duration(rest))))
+	}
+}
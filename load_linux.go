@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readLoadCounters reads the CPU times from /proc/stat, counting iowait as
+// idle, and sums the receive and transmit drops of every interface but
+// loopback from /proc/net/dev.
+func readLoadCounters() (loadCounters, error) {
+	var c loadCounters
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return c, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[0] != "cpu" {
+		return c, errors.New("/proc/stat: unexpected format")
+	}
+	for i, f := range fields[1:] {
+		n, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return c, errors.New("/proc/stat: unexpected format")
+		}
+		c.cpuTotal += n
+		// user nice system idle iowait ...
+		if i != 3 && i != 4 {
+			c.cpuBusy += n
+		}
+	}
+
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name, counters, ok := strings.Cut(sc.Text(), ":")
+		if !ok || strings.TrimSpace(name) == "lo" {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 12 {
+			continue
+		}
+		for _, i := range []int{3, 11} {
+			n, _ := strconv.ParseUint(fields[i], 10, 64)
+			c.drops += n
+		}
+	}
+	return c, sc.Err()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func readLoadCounters() (loadCounters, error) {
+	return loadCounters{}, errors.New("--sample-load is only supported on Linux")
+}
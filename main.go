@@ -1,144 +1,543 @@
-package main
-
-import (
-	"encoding/json"
-	"fmt"
-	"log"
-	"net"
-	"net/http"
-	"os"
-	"os/signal"
-	"strconv"
-	"sync"
-	"syscall"
-	"time"
-
-	"github.com/fatih/color"
-)
-
-type ConnectionStats struct {
-	sync.Mutex
-	Attempted int
-	Connected int
-	Failed    int
-	MinTime   time.Duration
-	MaxTime   time.Duration
-	TotalTime time.Duration
-}
-
-type IPInfo struct {
-	Org string `json:"org"`
-}
-
-var logger = log.New(os.Stdout, "", 0)
-
-func isValidIP(ip string) bool {
-	return net.ParseIP(ip) != nil
-}
-
-func isValidPort(port int) bool {
-	return port >= 0 && port <= 65535
-}
-
-func ping(host string, port int, stats *ConnectionStats) {
-	stats.Lock()
-	defer stats.Unlock()
-
-	startTime := time.Now()
-
-	ipInfo, err := getIPInfo(host)
-	if err != nil {
-		logger.Printf(color.RedString("Failed to get IP info: %v\n", err))
-		stats.Failed++
-		return
-	}
-
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), time.Second*5)
-	if err != nil {
-		logger.Printf(color.RedString("Connection timed out\n"))
-		stats.Failed++
-		return
-	}
-	defer conn.Close()
-
-	duration := time.Since(startTime)
-	logger.Printf("Connected to "+color.GreenString("%s")+ " time="+color.GreenString("%.2fms")+ " protocol="+color.GreenString("TCP")+ " port="+color.GreenString("%d")+ " ISP="+color.GreenString("%s")+"\n", host, float64(duration.Milliseconds()), port, ipInfo.Org)
-
-	stats.Connected++
-	stats.TotalTime += duration
-
-	if stats.MinTime == 0 || duration < stats.MinTime {
-		stats.MinTime = duration
-	}
-	if duration > stats.MaxTime {
-		stats.MaxTime = duration
-	}
-	stats.Attempted++
-}
-
-func getIPInfo(ip string) (*IPInfo, error) {
-	resp, err := http.Get(fmt.Sprintf("http://ipinfo.io/%s/json", ip))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var ipInfo IPInfo
-	err = json.NewDecoder(resp.Body).Decode(&ipInfo)
-	if err != nil {
-		return nil, err
-	}
-
-	return &ipInfo, nil
-}
-
-func main() {
-	if len(os.Args) != 3 {
-		logger.Fatal("Usage: go run main.go ip port")
-	}
-
-	host := os.Args[1]
-	if !isValidIP(host) {
-		logger.Fatal("Invalid IP address:", host)
-	}
-
-	port, err := strconv.Atoi(os.Args[2])
-	if err != nil || !isValidPort(port) {
-		logger.Fatal("Invalid port number:", err)
-	}
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	stats := &ConnectionStats{}
-
-	go func() {
-		<-c
-		printReport(stats)
-		os.Exit(0)
-	}()
-
-	var wg sync.WaitGroup
-	for {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			ping(host, port, stats)
-		}()
-		time.Sleep(time.Millisecond * 550)
-	}
-}
-
-func printReport(stats *ConnectionStats) {
-	stats.Lock()
-	defer stats.Unlock()
-
-	successRate := float64(stats.Connected) / float64(stats.Attempted) * 100
-	logger.Printf("\nConnection statistics:\n")
-	logger.Printf("Attempted = "+color.CyanString("%d")+", Connected = "+color.CyanString("%d")+", Failed = "+color.CyanString("%d")+" ("+color.CyanString("%.2f%%")+")\n", stats.Attempted, stats.Connected, stats.Failed, successRate)
-	logger.Printf("Approximate connection times:\n")
-
-	if stats.Connected > 0 {
-		averageTime := float64(stats.TotalTime.Milliseconds()) / float64(stats.Connected)
-		logger.Printf(" Minimum = "+color.CyanString("%.2fms")+", Maximum = "+color.CyanString("%.2fms")+", Average = "+color.CyanString("%.2fms")+"\n", float64(stats.MinTime.Milliseconds()), float64(stats.MaxTime.Milliseconds()), averageTime)
-	}
-}
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+	"paping/probe"
+)
+
+type Target struct {
+	Host   string
+	Port   int
+	Proto  string
+	Stats  *ConnectionStats
+	Dialer *probe.Dialer
+
+	seq atomic.Int64
+	// ctx is cancelled by Stop; the probing loops watch it between probes
+	// and it aborts the dial and retries of the probe in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// limit enforces --rate on every probe sent to t, bursts included.
+	limit *tokenBucket
+
+	// hold is open while the target is in maintenance; holdTimer ends a
+	// maintenance started for a set time (see override.go).
+	mu        sync.Mutex
+	hold      chan struct{}
+	holdTimer *time.Timer
+}
+
+// Addr names t as host:port, or by its path for a Unix socket.
+func (t *Target) Addr() string {
+	if isUnixNet(t.Proto) {
+		return t.Host
+	}
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// Stop makes the probing loops for t return, abandoning the probe in
+// flight.
+func (t *Target) Stop() {
+	t.cancel()
+}
+
+// logger prints probe lines and reports through color.Output, which turns
+// ANSI colors into console calls on Windows terminals that lack them.
+var logger = log.New(color.Output, "", 0)
+
+var (
+	dialer = &probe.Dialer{Timeout: 5 * time.Second}
+	geo    GeoLookup
+)
+
+var (
+	tuiMode    = flag.Bool("tui", false, "show a full-screen dashboard with a panel per target")
+	layoutName = flag.String("layout", layoutAuto, "probe line layout: auto, compact, normal or wide")
+	formatSpec = flag.String("format", formatText, "probe line format: text, json, csv or a Go template such as \"{{.Seq}} {{.Host}} {{.RTT}}\" (non-text formats print only probe lines on stdout)")
+
+	noColor        = flag.Bool("no-color", false, "disable colors, e.g. when piping into a file")
+	quiet          = flag.Bool("q", false, "print only the final report, no per-probe lines")
+	showLevel      = flag.String("show", showAll, "probe lines to print: all, failures (failed probes only) or changes (only UP/DOWN and other state changes); sinks still get every result")
+	onlyFailures   = flag.Bool("only-failures", false, "same as --show failures")
+	maxLinesPerSec = flag.Int("max-lines-per-sec", 0, "print at most this many probe lines per second, counting the rest (0 = no limit); sinks still get every result")
+	logLevel       = flag.String("log-level", "info", "least severe diagnostics to print on stderr: debug, info, warn or error (with --format json they are JSON lines)")
+	printConfig    = flag.Bool("print-config", false, "print every flag's resolved value and where it came from (default, --proto, a --preset or the command line) as JSON, then exit")
+	verbose        = flag.Bool("v", false, "add the local address and port, the kernel's TCP round trip and retransmits (Linux), resolved addresses and resolver to each probe line")
+
+	noLookup       = flag.Bool("no-lookup", false, "do not look up the ISP of targets")
+	lookupProvider = flag.String("lookup-provider", providerIPInfo, "ISP lookup provider: ipinfo, ip-api or maxmind")
+	lookupToken    = flag.String("lookup-token", "", "API token for the ipinfo or ip-api lookup provider")
+	maxmindDB      = flag.String("maxmind-db", "", "path to a GeoLite2-ASN database for --lookup-provider maxmind")
+	lookupTimeout  = flag.Duration("lookup-timeout", 5*time.Second, "give up on an ISP lookup request after this long")
+	lookupFailures = flag.Int("lookup-max-failures", 5, "skip ISP lookups for a minute after this many fail in a row (0 = never); probes carry on without an ISP either way")
+	lookupTTL      = flag.Duration("lookup-ttl", time.Hour, "how long to cache ISP lookups")
+	lookupCache    = flag.String("lookup-cache", "", "persist the ISP lookup cache in this JSON file")
+	diagnoseDown   = flag.Bool("diagnose", false, "when a target goes DOWN, log the default route, interface states, resolvers and public IP, and list them with the outage in the report")
+	speedOfLight   = flag.Bool("speed-of-light", false, "locate this machine and each target and compare the average with the fastest round trip light in fibre allows")
+	infoFieldSpec  = flag.String("info-fields", infoOrg, "IP info shown on probe lines, comma-separated: org, asn, country")
+	rdnsMode       = flag.Bool("rdns", false, "show the reverse DNS (PTR) name of the probed address next to it")
+	resolveEach    = flag.Bool("resolve-each", false, "announce and record when the target's address moves to a different ISP between probes")
+
+	summaryEvery  = flag.Duration("summary-every", 0, "print each target's loss and average for the past interval this often, e.g. 1m (0 = off)")
+	spikeCeiling  = flag.Duration("spike-ceiling", 0, "flag probes slower than this as latency spikes, e.g. 200ms (0 = off)")
+	spikeAlert    = flag.Bool("spike-alert", false, "flush the sinks on every latency spike, so a --webhook batch goes out at once")
+	voipMode      = flag.Bool("voip", false, "estimate VoIP call quality (MOS and R-factor) from latency, jitter and loss in the report")
+	unitsFormat   = flag.String("units-format", unitsIEC, "units for byte sizes in the report: iec (KiB, MiB) or si (kB, MB)")
+	thousandsSep  = flag.String("thousands-sep", "", "separate the thousands of large numbers in reports and probe lines with this, e.g. , or a space")
+	secondsAbove  = flag.Duration("seconds-above", 0, "show latencies from this long up in seconds, e.g. 1s (0 = always in milliseconds)")
+	sampleLoad    = flag.Bool("sample-load", false, "sample this machine's CPU use and NIC drops (Linux) and flag probes taken while it was overloaded, so local delays are not blamed on the network")
+	gameMode      = flag.Bool("game", false, "judge the run against what online games need (ping, jitter, loss) and name its worst periods in the report")
+	estimatorName = flag.String("estimator", estimatorReservoir, "percentile estimator: reservoir (uniform sample) or histogram (fixed log buckets)")
+	sampleCap     = flag.Int("sample-cap", 10000, "maximum connection times kept per target by the reservoir estimator")
+
+	protoName     = flag.String("proto", protoTCP, "probe protocol: tcp (connect), quic (handshake, needs a build with -tags quic), or any other network net.Dial takes: tcp4, tcp6, udp, udp4, udp6, or unix, unixgram and unixpacket with socket paths as targets")
+	compareProtos = flag.String("compare", "", "probe each target over several protocols at once and report them side by side, e.g. tcp,icmp,https")
+	quicALPN      = flag.String("alpn", "h3", "ALPN protocols offered in a QUIC handshake, comma-separated")
+	userPerceived = flag.Bool("user-perceived", false, "time a cold DNS lookup plus connect on every probe and report the combined figure, like a fresh client")
+	expectClosed  = flag.Bool("expect-closed", false, "expect the port to be closed, e.g. to check that a firewall blocks it: refused, timed-out and unreachable connections count as successes and open ones as failures")
+	halfOpen      = flag.Bool("half-open", false, "experimental: alternate half-open SYN probes with full connects and report the difference as the server's accept delay (needs root)")
+	dnsServer     = flag.String("dns", "", "resolve hostnames through this DNS server (host or host:port)")
+	ifaceName     = flag.String("interface", "", "send probes from this network interface or local IP address")
+	wgConfig      = flag.String("wg-config", "", "send probes through a userspace WireGuard tunnel described by this wg-quick config (needs a build with -tags wireguard)")
+	proxyProtocol = flag.String("proxy-protocol", "", "send a PROXY protocol header (v1 or v2) right after connecting, for backends behind HAProxy that require one")
+	proxyFlag     = flag.String("proxy-chain", "", "connect through these proxies in order, e.g. socks5://a:1080,http://b:3128")
+	tosValue      = flag.Int("tos", 0, "set this TOS byte on probe sockets (traffic class on IPv6), e.g. 0xb8, to see how QoS-marked traffic is treated")
+	dscpName      = flag.String("dscp", "", "set this DSCP code point on probe sockets: 0-63 or a name such as ef, af41 or cs1")
+	ttlValue      = flag.Int("ttl", 0, "set this TTL (hop limit on IPv6) on probe sockets (0 = system default)")
+	sockopts      sockoptFlag
+	bannerMode    = flag.Bool("banner", false, "read and print the service banner once per target after connecting")
+	bannerSize    = flag.Int("banner-size", 256, "maximum banner bytes to read with --banner")
+	edgeHeader    = flag.String("edge-id-header", "", "send an HTTP HEAD after connecting and record this response header as the answering edge")
+	breakdownSpec = flag.String("breakdown", "", "after connecting, also do a TLS handshake, an HTTP HEAD request or both (tls, http or tls,http) and time DNS, TCP, TLS and HTTP separately on probe lines and in the report")
+	edgeTLS       = flag.Bool("edge-tls", false, "do a TLS handshake after connecting and record the certificate CN as the answering edge")
+	probeSize     = flag.Int("probe-size", 0, "with --keepalive or --breakdown http, move this many bytes each probe and report the throughput: echoed back with --keepalive, downloaded by a ranged GET with --breakdown http")
+	checkName     = flag.String("check", "", "after connecting, speak this protocol (smtp, ftp, redis or mysql-handshake) and fail the probe unless the service answers as expected")
+
+	jobsStdin = flag.Bool("jobs-stdin", false, "read \"host port [proto]\" jobs from stdin and print each result as a JSON line")
+
+	daemonMode     = flag.Bool("daemon", false, "same as the serve command")
+	listenAddr     = flag.String("listen", "127.0.0.1:8765", "address the serve control API or the echo server listens on")
+	agentID        = flag.String("agent-id", hostname(), "serve: name of this agent in gossiped health views")
+	peers          = flag.String("peers", "", "serve: other agents to gossip target health with, e.g. a:8765,b:8765")
+	gossipInterval = flag.Duration("gossip-interval", 5*time.Second, "serve: how often to exchange health views with a peer")
+	gossipToken    = flag.String("gossip-token", "", "serve: shared secret that agents must present to exchange health views")
+	historyRetain  = flag.Duration("history", 24*time.Hour, "serve: keep every probe in memory this long, compressed, for GET /history (0 = off)")
+
+	failoverIfaces = flag.String("interfaces", "", "failover: interfaces or local IPs to probe from, primary first, e.g. eth0,wwan0 (default: every interface that is up)")
+
+	portsSpec       = flag.String("p", "", "scan: ports to check, e.g. 1-1024 or 22,80,443")
+	scanConcurrency = flag.Int("concurrency", 100, "scan: maximum ports checked at once")
+
+	traceMode    = flag.Bool("trace", false, "same as the trace command")
+	mtrMode      = flag.Bool("mtr", false, "trace: continuously probe every hop to the target and show live per-hop loss and latency, like mtr")
+	maxHops      = flag.Int("max-hops", 30, "maximum TTL to try in trace and mtr modes")
+	traceQueries = flag.Int("trace-queries", 3, "probes per hop in trace mode")
+
+	runDuration   = flag.Duration("duration", 0, "stop after this long and print the report, e.g. 2h (0 = no limit)")
+	startAt       = flag.String("start-at", "", "wait until this time to start probing, e.g. 02:00 or \"2024-06-01 02:00\"")
+	untilTime     = flag.String("until", "", "stop probing at this time and print the report, e.g. 04:00")
+	count         = flag.Int("count", 0, "stop after this many probes per target and print the report (0 = run until interrupted)")
+	probeTimeout  = flag.Duration("w", 5*time.Second, "how long to wait for each connection (1s for unix sockets)")
+	presetSpec    = flag.String("preset", "", "apply the interval, timeout, retries and thresholds for a kind of network: lan, wan, satellite or mobile; several, as in wan,mobile, apply in order and flags given override them")
+	interval      = flag.Duration("interval", 550*time.Millisecond, "time between probes")
+	adaptiveMode  = flag.Bool("adaptive", false, "send the next probe as soon as the previous one completes")
+	floodMode     = flag.Bool("flood", false, "send probes as fast as --rate and --max-concurrent allow")
+	maxConcurrent = flag.Int("max-concurrent", 64, "maximum probes in flight at once across all targets")
+	keepaliveMode = flag.Bool("keepalive", false, "keep one connection open and time 1-byte round trips on it instead of reconnecting")
+	warmMode      = flag.Bool("warm", false, "after connecting, send two application pings on the connection and compare connect with warm round trip")
+	warmGap       = flag.Duration("warm-gap", 100*time.Millisecond, "pause between the two --warm pings")
+	rateBurst     = flag.Int("burst", 1, "probes --rate lets through back to back before it starts spacing them out")
+	retries       = flag.Int("retries", 0, "retry a failed probe this many times before counting it as lost")
+	retryDelay    = flag.Duration("retry-delay", 200*time.Millisecond, "delay before the first retry, doubling for each further one")
+	probeRate     rateFlag
+	bandwidth     bandwidthFlag
+	jitter        percentFlag
+	spikeFactor   factorFlag
+
+	sinkSpecs sinkFlags
+
+	maxAvg  = flag.Duration("max-avg", 0, "assert: fail if the average connect time exceeds this")
+	maxP95  = flag.Duration("max-p95", 0, "assert: fail if the 95th percentile connect time exceeds this")
+	maxP99  = flag.Duration("max-p99", 0, "assert: fail if the 99th percentile connect time exceeds this")
+	maxLoss percentFlag
+
+	waitFor     = flag.Bool("wait-for", false, "block until every target accepts --consecutive connections in a row, then exit 0 (1 on --timeout)")
+	consecutive = flag.Int("consecutive", 3, "consecutive successful connections --wait-for needs, at most 60")
+	waitTimeout = flag.Duration("timeout", 0, "give up --wait-for after this long (0 = wait forever)")
+
+	storeSpec   = flag.String("store", "", "persist every probe result, e.g. sqlite:paping.db; read back by paping report")
+	reportSince = flag.Duration("since", 0, "report: only use results from this long ago onwards, e.g. 24h (0 = all)")
+	sessionOut  = flag.String("out", "", "record: file to save the session summary to, for paping diff")
+
+	rotateEvery = flag.Duration("rotate-every", 0, "json and csv sinks: start a new file after this long, e.g. 24h (0 = never)")
+	retainAge   = flag.Duration("retain", 0, "json and csv sinks: delete rotated files older than this (0 = keep)")
+	rotateSize  sizeFlag
+	retainSize  sizeFlag
+
+	icsPath = flag.String("ics", "", "write each outage as an event to this iCalendar file")
+
+	webhookURL      = flag.String("webhook", "", "POST probe results as JSON batches to this URL")
+	webhookBatch    = flag.Int("webhook-batch", 10, "send a webhook batch once this many results are queued")
+	webhookInterval = flag.Duration("webhook-interval", 5*time.Second, "send queued webhook results at least this often")
+	webhookSecret   = flag.String("webhook-secret", "", "sign webhook payloads with HMAC-SHA256 using this shared secret")
+)
+
+func init() {
+	flag.Var(&sinkSpecs, "sink", "send results to a registered sink, as name:config (repeatable)")
+	flag.Var(&maxLoss, "max-loss", "assert: fail if more than this percentage of probes is lost, e.g. 1%")
+	flag.Var(&rotateSize, "rotate-size", "json and csv sinks: start a new file once the current one would grow past this, e.g. 500MB or 500MiB (K, M and G are powers of 1024); rotated files are gzipped")
+	flag.Var(&retainSize, "retain-size", "json and csv sinks: delete the oldest rotated files while they take more than this, e.g. 5GB")
+	flag.Var(&spikeFactor, "spike-threshold", "flag probes slower than this multiple of the moving average as latency spikes, e.g. 3x")
+	flag.Var(&jitter, "interval-jitter", "move each pause by up to this share of --interval either way at random, e.g. 20%")
+	flag.Var(&bandwidth, "bandwidth", "link speed, e.g. 100Mbit or 1Gbit, to size TCP windows and buffers from the bandwidth-delay product in the report")
+	flag.Var(&sockopts, "sockopt", "set a socket option on probe sockets before connecting, as NAME=VALUE (e.g. TCP_NODELAY=1, SO_RCVBUF=262144) or LEVEL:OPTION=VALUE in numbers (repeatable)")
+	flag.Var(&probeRate, "rate", "maximum probes per target, e.g. 100/s or 30/m, enforced by a token bucket in every mode")
+}
+
+func isValidIP(ip string) bool {
+	return net.ParseIP(ip) != nil
+}
+
+func isValidHost(host string) bool {
+	if isValidIP(host) {
+		return true
+	}
+	return host != "" && !strings.ContainsAny(host, " /:")
+}
+
+func isValidPort(port int) bool {
+	return port >= 0 && port <= 65535
+}
+
+// parseArgs parses flags wherever they appear on the command line and
+// returns the remaining positional arguments in order.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseTargets accepts either the classic "ip port" pair or any number of
+// "ip:port" arguments.
+func parseTargets(args []string) ([]*Target, error) {
+	if len(args) == 2 && !isUnixNet(*protoName) {
+		if _, err := strconv.Atoi(args[1]); err == nil && !hasPort(args[0]) {
+			args = []string{net.JoinHostPort(args[0], args[1])}
+		}
+	}
+	if len(args) == 0 {
+		return nil, errors.New("no targets given")
+	}
+
+	var targets []*Target
+	for _, arg := range args {
+		if isUnixNet(*protoName) {
+			t := &Target{Host: arg, Proto: *protoName, Stats: &ConnectionStats{}, Dialer: dialer}
+			t.ctx, t.cancel = context.WithCancel(context.Background())
+			t.limit = newTokenBucket(probeRate, *rateBurst)
+			targets = append(targets, t)
+			continue
+		}
+		if *protoName == protoQUIC && !hasPort(arg) {
+			arg = net.JoinHostPort(arg, "443")
+		}
+		host, portStr, err := net.SplitHostPort(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q: %w", arg, err)
+		}
+		if !isValidHost(host) {
+			return nil, fmt.Errorf("invalid host: %s", host)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || !isValidPort(port) {
+			return nil, fmt.Errorf("invalid port number: %s", portStr)
+		}
+		t := &Target{Host: host, Port: port, Proto: *protoName, Stats: &ConnectionStats{}, Dialer: dialer}
+		t.ctx, t.cancel = context.WithCancel(context.Background())
+		t.limit = newTokenBucket(probeRate, *rateBurst)
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+func checkScheduleFlags() error {
+	if *adaptiveMode && *floodMode {
+		return errors.New("--adaptive and --flood are mutually exclusive")
+	}
+	if *keepaliveMode && (*adaptiveMode || *floodMode) {
+		return errors.New("--keepalive cannot be combined with --adaptive or --flood")
+	}
+	if *keepaliveMode && *userPerceived {
+		return errors.New("--user-perceived cannot be combined with --keepalive")
+	}
+	if *keepaliveMode && *warmMode {
+		return errors.New("--keepalive and --warm are mutually exclusive")
+	}
+	if *interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+	if *count < 0 {
+		return errors.New("--count must not be negative")
+	}
+	if *probeTimeout <= 0 {
+		return errors.New("-w must be positive")
+	}
+	if *waitFor && (*keepaliveMode || *adaptiveMode || *floodMode || *count > 0) {
+		return errors.New("--wait-for cannot be combined with --keepalive, --adaptive, --flood or --count")
+	}
+	if *runDuration < 0 {
+		return errors.New("--duration must not be negative")
+	}
+	if *waitFor && (*runDuration > 0 || *startAt != "" || *untilTime != "") {
+		return errors.New("--wait-for cannot be combined with --duration, --start-at or --until; use --timeout")
+	}
+	if _, _, err := runWindow(time.Now()); err != nil {
+		return err
+	}
+	if *consecutive < 1 {
+		return errors.New("--consecutive must be at least 1")
+	}
+	// isUp looks for the streak in the kept history of probe results.
+	if *consecutive > historySize {
+		return fmt.Errorf("--consecutive must be at most %d", historySize)
+	}
+	if *waitTimeout < 0 {
+		return errors.New("--timeout must not be negative")
+	}
+	if *retries < 0 {
+		return errors.New("--retries must not be negative")
+	}
+	if err := checkProto(*protoName); err != nil {
+		return err
+	}
+	if *protoName == protoQUIC && (*keepaliveMode || *warmMode || *bannerMode || edgeEnabled() || *proxyFlag != "" || *wgConfig != "" || *proxyProtocol != "") {
+		return errors.New("--proto quic cannot be combined with --keepalive, --warm, --banner, --edge-*, --proxy-chain, --proxy-protocol or --wg-config")
+	}
+	if *halfOpen && (*keepaliveMode || *protoName == protoQUIC || *proxyFlag != "" || *wgConfig != "") {
+		return errors.New("--half-open cannot be combined with --keepalive, --proto quic, --proxy-chain or --wg-config")
+	}
+	if *protoName != protoTCP && *protoName != protoQUIC && (*proxyFlag != "" || *wgConfig != "" || *proxyProtocol != "") {
+		return fmt.Errorf("--proto %s cannot be combined with --proxy-chain, --proxy-protocol or --wg-config", *protoName)
+	}
+	if *halfOpen && !strings.HasPrefix(*protoName, "tcp") {
+		return fmt.Errorf("--half-open cannot be combined with --proto %s", *protoName)
+	}
+	if err := checkBreakdown(); err != nil {
+		return err
+	}
+	if err := checkProtocol(); err != nil {
+		return err
+	}
+	if err := checkProbeSize(); err != nil {
+		return err
+	}
+	if *checkName != "" && (*keepaliveMode || *warmMode || *halfOpen || *expectClosed || *bannerMode || edgeEnabled() || breakdownEnabled() || *protoName == protoQUIC || strings.HasPrefix(*protoName, "udp") || *protoName == "unixgram") {
+		return errors.New("--check cannot be combined with --keepalive, --warm, --half-open, --expect-closed, --banner, --edge-*, --breakdown, --proto quic or a datagram --proto")
+	}
+	if breakdownEnabled() && (*keepaliveMode || *warmMode || *halfOpen || *expectClosed || *protoName == protoQUIC || strings.HasPrefix(*protoName, "udp") || *protoName == "unixgram") {
+		return errors.New("--breakdown cannot be combined with --keepalive, --warm, --half-open, --expect-closed, --proto quic or a datagram --proto")
+	}
+	if *expectClosed && (*keepaliveMode || *warmMode || *halfOpen || *bannerMode || edgeEnabled() || *compareProtos != "") {
+		return errors.New("--expect-closed cannot be combined with --keepalive, --warm, --half-open, --banner, --edge-* or --compare")
+	}
+	if *expectClosed && (strings.HasPrefix(*protoName, "udp") || *protoName == "unixgram") {
+		return fmt.Errorf("--expect-closed cannot be combined with --proto %s, which connects without asking the other end", *protoName)
+	}
+	if isUnixNet(*protoName) && (*ifaceName != "" || markTOS != 0 || *ttlValue != 0 || *speedOfLight) {
+		return fmt.Errorf("--proto %s cannot be combined with --interface, --tos, --dscp, --ttl or --speed-of-light", *protoName)
+	}
+	if *compareProtos != "" {
+		if _, err := parseCompare(*compareProtos); err != nil {
+			return err
+		}
+		if *keepaliveMode || *adaptiveMode || *floodMode || *waitFor || *tuiMode || *jobsStdin {
+			return errors.New("--compare cannot be combined with --keepalive, --adaptive, --flood, --wait-for, --tui or --jobs-stdin")
+		}
+	}
+	if *spikeCeiling < 0 {
+		return errors.New("--spike-ceiling must not be negative")
+	}
+	if *spikeAlert && !spikesEnabled() {
+		return errors.New("--spike-alert needs --spike-threshold or --spike-ceiling")
+	}
+	if *speedOfLight && (*noLookup || *lookupProvider == providerMaxMind) {
+		return errors.New("--speed-of-light needs the ipinfo or ip-api lookup provider")
+	}
+	if *rateBurst < 1 {
+		return errors.New("--burst must be at least 1")
+	}
+	if *maxConcurrent < 1 {
+		return errors.New("--max-concurrent must be at least 1")
+	}
+	return nil
+}
+
+const (
+	protoTCP  = "tcp"
+	protoQUIC = "quic"
+)
+
+func checkProto(proto string) error {
+	switch proto {
+	case protoTCP, "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram", "unixpacket":
+		return nil
+	case protoQUIC:
+		if !quicSupported {
+			return errors.New("this build has no QUIC support; rebuild with -tags quic")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported protocol %q, want tcp, quic or a network such as tcp6, udp or unix", proto)
+}
+
+// isUnixNet reports whether proto is a Unix domain socket network, whose
+// targets are paths instead of host and port.
+func isUnixNet(proto string) bool {
+	return strings.HasPrefix(proto, "unix")
+}
+
+// newDialContext builds the dial function for the --interface, --wg-config,
+// --proxy-chain and --proxy-protocol flags on top of the probe package's
+// dial hook.
+func newDialContext(iface, wgConfig, proxies string) (probe.DialContextFunc, error) {
+	var dial probe.DialContextFunc
+	if wgConfig != "" {
+		if iface != "" {
+			return nil, errors.New("--wg-config cannot be combined with --interface")
+		}
+		var err error
+		if dial, err = wireguardDialer(wgConfig); err != nil {
+			return nil, err
+		}
+	} else {
+		local, err := localAddr(iface)
+		if err != nil {
+			return nil, err
+		}
+		dial = probe.ControlDialer(local, socketControl())
+	}
+
+	chain, err := probe.ParseProxyChain(proxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) > 0 {
+		dial = probe.ProxyChain(chain, dial)
+	}
+
+	if err := checkProxyProtocol(*proxyProtocol); err != nil {
+		return nil, err
+	}
+	if *proxyProtocol != "" {
+		dial = withProxyHeader(dial, *proxyProtocol)
+	}
+	return dial, nil
+}
+
+// localAddr resolves --interface, which may name an interface or give one
+// of the machine's IP addresses.
+func localAddr(iface string) (net.Addr, error) {
+	if iface == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(iface); ip != nil {
+		return &net.TCPAddr{IP: ip}, nil
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return &net.TCPAddr{IP: ipnet.IP}, nil
+		}
+	}
+	if len(addrs) > 0 {
+		if ipnet, ok := addrs[0].(*net.IPNet); ok {
+			return &net.TCPAddr{IP: ipnet.IP}, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IP address", iface)
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "paping"
+	}
+	return name
+}
+
+func hasPort(arg string) bool {
+	_, _, err := net.SplitHostPort(arg)
+	return err == nil
+}
+
+func main() {
+	flag.Usage = usage
+	args, err := parseArgs(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	if *noColor {
+		color.NoColor = true
+	}
+	if err := setupDiag(); err != nil {
+		fatal(2, err)
+	}
+
+	cmd, args := commandFor(args)
+	if cmd == nil {
+		if len(args) > 0 && findCommand(args[0]) != nil {
+			commandUsage(findCommand(args[0]))
+		} else {
+			usage()
+		}
+		return
+	}
+	if err := checkCommandFlags(cmd); err != nil {
+		fatal(2, err)
+	}
+	cmd.run(args)
+}
+
+// runAll probes every target concurrently and returns once they have all
+// used up --count, which without --count is never.
+func runAll(targets []*Target) {
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t *Target) {
+			defer wg.Done()
+			run(t)
+		}(t)
+	}
+	wg.Wait()
+}
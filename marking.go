@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// markTOS is the TOS byte (IPv6 traffic class) set on probe sockets, from
+// --tos or --dscp; 0 leaves the system default.
+var markTOS int
+
+// checkMarking validates --tos, --dscp, --ttl and --sockopt and resolves
+// markTOS.
+func checkMarking() error {
+	if *tosValue != 0 && *dscpName != "" {
+		return errors.New("--tos and --dscp are mutually exclusive")
+	}
+	if *tosValue < 0 || *tosValue > 255 {
+		return errors.New("--tos must be between 0 and 255")
+	}
+	markTOS = *tosValue
+	if *dscpName != "" {
+		dscp, err := parseDSCP(*dscpName)
+		if err != nil {
+			return err
+		}
+		markTOS = dscp << 2
+	}
+	if *ttlValue < 0 || *ttlValue > 255 {
+		return errors.New("--ttl must be between 1 and 255")
+	}
+	if (markTOS != 0 || *ttlValue != 0) && (*wgConfig != "" || *protoName == protoQUIC) {
+		return errors.New("--tos, --dscp and --ttl cannot be combined with --wg-config or --proto quic")
+	}
+	if len(sockopts) > 0 && (*wgConfig != "" || *protoName == protoQUIC) {
+		return errors.New("--sockopt cannot be combined with --wg-config or --proto quic")
+	}
+	return nil
+}
+
+// parseDSCP accepts a code point from 0 to 63 or one of the standard names:
+// ef, le, cs0 to cs7 and af11 to af43.
+func parseDSCP(v string) (int, error) {
+	name := strings.ToLower(v)
+	switch {
+	case name == "ef":
+		return 46, nil
+	case name == "le":
+		return 1, nil
+	case len(name) == 3 && strings.HasPrefix(name, "cs") && name[2] >= '0' && name[2] <= '7':
+		return int(name[2]-'0') * 8, nil
+	case len(name) == 4 && strings.HasPrefix(name, "af") && name[2] >= '1' && name[2] <= '4' && name[3] >= '1' && name[3] <= '3':
+		return int(name[2]-'0')*8 + int(name[3]-'0')*2, nil
+	}
+	n, err := strconv.ParseInt(v, 0, 0)
+	if err != nil || n < 0 || n > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q, want 0-63, ef, le, cs0-cs7 or af11-af43", v)
+	}
+	return int(n), nil
+}
+
+// markingControl returns the socket control function that applies the
+// marking, or nil when there is none.
+func markingControl() func(network, address string, c syscall.RawConn) error {
+	if markTOS == 0 && *ttlValue == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = setMarking(fd, strings.HasSuffix(network, "6"), markTOS, *ttlValue)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// printMarking prints the marking and --sockopt options in the session
+// header, if any.
+func printMarking() {
+	var parts []string
+	if markTOS != 0 {
+		parts = append(parts, fmt.Sprintf("DSCP %d (TOS 0x%02x)", markTOS>>2, markTOS))
+	}
+	if *ttlValue != 0 {
+		parts = append(parts, fmt.Sprintf("TTL %d", *ttlValue))
+	}
+	if len(parts) > 0 {
+		diag.Info("Marking probes with " + strings.Join(parts, ", "))
+	}
+	if len(sockopts) > 0 {
+		diag.Info("Setting socket options " + strings.ReplaceAll(sockopts.String(), ",", ", "))
+	}
+}
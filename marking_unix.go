@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setMarking sets the TOS byte (traffic class on IPv6) and TTL (hop limit)
+// of a socket; zero leaves either alone.
+func setMarking(fd uintptr, v6 bool, tos, ttl int) error {
+	level, tosOpt, ttlOpt := unix.IPPROTO_IP, unix.IP_TOS, unix.IP_TTL
+	if v6 {
+		level, tosOpt, ttlOpt = unix.IPPROTO_IPV6, unix.IPV6_TCLASS, unix.IPV6_UNICAST_HOPS
+	}
+	if tos != 0 {
+		if err := unix.SetsockoptInt(int(fd), level, tosOpt, tos); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if ttl != 0 {
+		if err := unix.SetsockoptInt(int(fd), level, ttlOpt, ttl); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	return nil
+}
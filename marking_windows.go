@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// setMarking sets the TOS byte and TTL (hop limit) of a socket; zero
+// leaves either alone. Windows has no option for the IPv6 traffic class,
+// and only honours IP_TOS when the DisableUserTOSSetting policy is off.
+func setMarking(fd uintptr, v6 bool, tos, ttl int) error {
+	level, ttlOpt := windows.IPPROTO_IP, windows.IP_TTL
+	if v6 {
+		if tos != 0 {
+			return errors.New("--tos and --dscp are not supported for IPv6 on Windows")
+		}
+		level, ttlOpt = windows.IPPROTO_IPV6, windows.IPV6_UNICAST_HOPS
+	}
+	if tos != 0 {
+		if err := windows.SetsockoptInt(windows.Handle(fd), level, windows.IP_TOS, tos); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if ttl != 0 {
+		if err := windows.SetsockoptInt(windows.Handle(fd), level, ttlOpt, ttl); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// runMTR probes every hop towards t once per round until stop is closed,
+// redrawing a live per-hop table, then prints the final table.
+func runMTR(t *Target, stop <-chan struct{}) error {
+	ip, err := resolveTrace(t)
+	if err != nil {
+		return err
+	}
+
+	hops := make([]*hopStats, *maxHops)
+	for i := range hops {
+		hops[i] = &hopStats{TTL: i + 1}
+	}
+	limit := *maxHops
+
+	fmt.Fprint(color.Output, enterAltScreen)
+	for {
+		if err := mtrRound(ip, t.Port, hops[:limit]); err != nil {
+			fmt.Fprint(color.Output, leaveAltScreen)
+			return err
+		}
+		for i, h := range hops[:limit] {
+			if h.Reached {
+				limit = i + 1
+				break
+			}
+		}
+		fmt.Fprint(color.Output, clearScreen+mtrTable(t, ip, hops[:limit]))
+
+		select {
+		case <-stop:
+			fmt.Fprint(color.Output, leaveAltScreen)
+			logger.Print(mtrTable(t, ip, hops[:limit]))
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// mtrRound sends one TTL-limited SYN per hop, all in parallel.
+func mtrRound(ip net.IP, port int, hops []*hopStats) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, h := range hops {
+		wg.Add(1)
+		go func(h *hopStats) {
+			defer wg.Done()
+			reply, err := traceHop(ip, port, h.TTL, traceTimeout)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			h.add(reply)
+		}(h)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func mtrTable(t *Target, ip net.IP, hops []*hopStats) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s  %s (%s) over TCP  %s\n\n", color.New(color.Bold).Sprint("paping mtr"), t.Addr(), ip, time.Now().Format("15:04:05"))
+	fmt.Fprintf(&b, "%3s  %-40s %6s %5s %9s %9s %9s %9s\n", "#", "Host", "Loss%", "Sent", "Last", "Avg", "Best", "Wrst")
+	for _, h := range hops {
+		addr := h.Addr
+		if addr == "" {
+			addr = "???"
+		}
+		width := len(addr)
+		if h.Reached {
+			width += 1 + len(hopLabel(h))
+			addr += " " + hopState(h)
+		}
+		if width < 40 {
+			addr += strings.Repeat(" ", 40-width)
+		}
+		loss := fmt.Sprintf("%5.1f%%", h.loss())
+		if h.Sent > h.Received {
+			loss = color.RedString("%s", loss)
+		}
+		fmt.Fprintf(&b, "%3d  %s %6s %5d %9s %9s %9s %9s\n", h.TTL, addr, loss, h.Sent,
+			formatHopTime(h.Last), formatHopTime(h.average()), formatHopTime(h.Best), formatHopTime(h.Worst))
+	}
+	return b.String()
+}
+
+func formatHopTime(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmtMs(ms(d))
+}
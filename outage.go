@@ -0,0 +1,53 @@
+package main
+
+import "time"
+
+// Outage is a period during which every probe to a target failed. It
+// starts at the first failed probe and ends at the next successful one.
+// Diagnostics is the --diagnose snapshot taken as it began.
+type Outage struct {
+	Target      string
+	Start       time.Time
+	End         time.Time
+	Failed      int
+	LastError   string
+	Diagnostics []string
+}
+
+func (o Outage) Duration() time.Duration {
+	return o.End.Sub(o.Start)
+}
+
+// outageTracker turns a stream of results for one target into outages.
+type outageTracker struct {
+	current *Outage
+}
+
+// observe feeds r to the tracker and returns the outage it ended, if any.
+func (tr *outageTracker) observe(r Result) *Outage {
+	if !r.Success {
+		if tr.current == nil {
+			tr.current = &Outage{Target: r.Target, Start: r.Time}
+		}
+		tr.current.Failed++
+		tr.current.LastError = r.Error
+		return nil
+	}
+
+	ended := tr.current
+	tr.current = nil
+	if ended != nil {
+		ended.End = r.Time
+	}
+	return ended
+}
+
+// close ends an outage still in progress at the given time.
+func (tr *outageTracker) close(at time.Time) *Outage {
+	ended := tr.current
+	tr.current = nil
+	if ended != nil {
+		ended.End = at
+	}
+	return ended
+}
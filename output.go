@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// OutputFormatter renders the line printed for each probe, newline
+// included. err is the probe error, nil for a successful probe.
+type OutputFormatter interface {
+	Format(r Result, err error) string
+}
+
+var (
+	formatter OutputFormatter = textFormatter{}
+	// resultLog prints probe lines of the machine-readable formats to
+	// stdout, while everything else goes to stderr so pipelines only see
+	// the lines.
+	resultLog = log.New(os.Stdout, "", 0)
+)
+
+// setFormat selects the formatter for --format: text, json, csv or a Go
+// template such as "{{.Seq}} {{.Host}} {{.RTT}}" executed on each Result.
+func setFormat(spec string) error {
+	switch spec {
+	case formatText:
+		formatter = textFormatter{}
+	case formatJSON:
+		formatter = jsonFormatter{}
+	case formatCSV:
+		formatter = &csvFormatter{}
+	default:
+		if !strings.Contains(spec, "{{") {
+			return fmt.Errorf("invalid format %q, want text, json, csv or a Go template", spec)
+		}
+		tmpl, err := template.New("format").Option("missingkey=error").Parse(spec)
+		if err == nil {
+			// Catch unknown fields now rather than on every probe line.
+			err = tmpl.Execute(io.Discard, Result{})
+		}
+		if err != nil {
+			return fmt.Errorf("--format: %w", err)
+		}
+		formatter = templateFormatter{tmpl}
+	}
+	return nil
+}
+
+// machineFormat reports whether probe lines are meant for other programs.
+func machineFormat() bool {
+	_, text := formatter.(textFormatter)
+	return !text
+}
+
+// textFormatter prints the human-readable lines in the --layout chosen.
+type textFormatter struct{}
+
+func (textFormatter) Format(r Result, err error) string {
+	layout, width := currentLayout()
+	switch layout {
+	case layoutCompact:
+		return compactLine(r)
+	case layoutWide:
+		return wideLine(r)
+	}
+	return wrapSegments(normalSegments(r, err), width)
+}
+
+type jsonFormatter struct{}
+
+func (jsonFormatter) Format(r Result, _ error) string {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Sprintf("{\"error\":%q}\n", err.Error())
+	}
+	return string(data) + "\n"
+}
+
+// csvFormatter prints the csvHeader columns, with the header first.
+type csvFormatter struct {
+	header sync.Once
+}
+
+func (f *csvFormatter) Format(r Result, _ error) string {
+	var line string
+	f.header.Do(func() { line = string(csvLine(csvHeader)) })
+	return line + string(csvLine(csvRecord(r)))
+}
+
+type templateFormatter struct {
+	tmpl *template.Template
+}
+
+func (f templateFormatter) Format(r Result, _ error) string {
+	var b strings.Builder
+	if err := f.tmpl.Execute(&b, r); err != nil {
+		return "format: " + err.Error() + "\n"
+	}
+	if !strings.HasSuffix(b.String(), "\n") {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func csvRecord(r Result) []string {
+	return []string{
+		r.Time.Format(time.RFC3339Nano),
+		r.Target,
+		r.IP,
+		r.Proto,
+		strconv.Itoa(r.Seq),
+		strconv.FormatBool(r.Success),
+		strconv.FormatFloat(r.RTT, 'f', -1, 64),
+		strconv.FormatFloat(r.DNS, 'f', -1, 64),
+		r.ISP,
+		r.Category,
+		r.Error,
+	}
+}
+
+// statusOutput is where reports and status messages go: stdout, unless a
+// machine-readable --format has it.
+func statusOutput() io.Writer {
+	if machineFormat() {
+		return color.Error
+	}
+	return color.Output
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultBurst is how many probes POST /burst sends without a count, and
+// maxBurst the most it accepts.
+const (
+	defaultBurst = 10
+	maxBurst     = 1000
+)
+
+// startMaintenance pauses probing t after the probe in flight until
+// endMaintenance is called or, if d is positive, for d. Starting it again
+// while t is in maintenance only replaces the end time.
+func (t *Target) startMaintenance(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.hold == nil {
+		t.hold = make(chan struct{})
+	}
+	if t.holdTimer != nil {
+		t.holdTimer.Stop()
+		t.holdTimer = nil
+	}
+	if d > 0 {
+		t.holdTimer = time.AfterFunc(d, func() {
+			if t.endMaintenance() {
+				diag.Info(fmt.Sprintf("Maintenance of %s is over", t.Addr()), "target", t.Addr())
+			}
+		})
+	}
+}
+
+// endMaintenance resumes probing t, reporting whether it was in
+// maintenance.
+func (t *Target) endMaintenance() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.hold == nil {
+		return false
+	}
+	close(t.hold)
+	t.hold = nil
+	if t.holdTimer != nil {
+		t.holdTimer.Stop()
+		t.holdTimer = nil
+	}
+	return true
+}
+
+func (t *Target) inMaintenance() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hold != nil
+}
+
+// awaitResume returns once t is out of maintenance or stopped.
+func (t *Target) awaitResume() {
+	t.mu.Lock()
+	hold := t.hold
+	t.mu.Unlock()
+	if hold == nil {
+		return
+	}
+	select {
+	case <-hold:
+	case <-t.ctx.Done():
+	}
+}
+
+// reresolve looks the host of t up again now and drops the IP info and
+// PTR names cached for the addresses it resolves to, so the next probe
+// fetches them afresh. DNS answers themselves are never cached here.
+func reresolve(t *Target) ([]string, error) {
+	ips, _, err := resolve(t.Host)
+	if err != nil {
+		return nil, err
+	}
+	cache, _ := geo.(*cachedLookup)
+	for _, ip := range ips {
+		if cache != nil {
+			cache.forget(ip)
+		}
+		ptrNames.Delete(ip)
+	}
+	return ips, nil
+}
+
+// burst sends n probes to t back to back, alongside its regular ones. They
+// take their tokens from the same --rate bucket, so a burst cannot exceed
+// the rate and slows the regular probes down while it lasts.
+func (d *daemon) burst(t *Target, n int) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for i := 0; i < n && t.ctx.Err() == nil; i++ {
+			if !t.limit.wait(t.ctx) {
+				break
+			}
+			acquireSlot()
+			ping(t)
+			releaseSlot()
+		}
+	}()
+}
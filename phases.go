@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// The phases of a probe that --breakdown times separately: resolving the
+// host, the TCP connect, and the TLS handshake and HTTP request --breakdown
+// adds on top.
+const (
+	phaseDNS  = "dns"
+	phaseTCP  = "tcp"
+	phaseTLS  = "tls"
+	phaseHTTP = "http"
+)
+
+var phaseNames = []string{phaseDNS, phaseTCP, phaseTLS, phaseHTTP}
+
+// breakdownTLS and breakdownHTTP are the layers named in --breakdown.
+var breakdownTLS, breakdownHTTP bool
+
+// checkBreakdown parses --breakdown, a comma-separated list of tls and
+// http.
+func checkBreakdown() error {
+	if *breakdownSpec == "" {
+		return nil
+	}
+	for _, layer := range strings.Split(*breakdownSpec, ",") {
+		switch strings.TrimSpace(layer) {
+		case phaseTLS:
+			breakdownTLS = true
+		case phaseHTTP:
+			breakdownHTTP = true
+		default:
+			return fmt.Errorf("invalid --breakdown layer %q, want tls, http or tls,http", layer)
+		}
+	}
+	return nil
+}
+
+func breakdownEnabled() bool {
+	return *breakdownSpec != ""
+}
+
+// phaseStats sums the times of one phase.
+type phaseStats struct {
+	Count    int
+	Min, Max time.Duration
+	Total    time.Duration
+}
+
+func (p *phaseStats) add(d time.Duration) {
+	if p.Count == 0 || d < p.Min {
+		p.Min = d
+	}
+	if d > p.Max {
+		p.Max = d
+	}
+	p.Count++
+	p.Total += d
+}
+
+// phaseTimes returns the time r spent in each phase it went through.
+func phaseTimes(r Result) map[string]float64 {
+	times := map[string]float64{phaseTCP: r.RTT}
+	for name, v := range map[string]float64{phaseDNS: r.DNS, phaseTLS: r.TLS, phaseHTTP: r.HTTP} {
+		if v > 0 {
+			times[name] = v
+		}
+	}
+	return times
+}
+
+// printPhases lists the minimum, average and maximum of each phase, to
+// show which layer a slow probe lost its time in.
+func printPhases(phases map[string]*phaseStats) {
+	logger.Printf("Phases (min / avg / max):\n")
+	for _, name := range phaseNames {
+		p := phases[name]
+		if p == nil {
+			continue
+		}
+		logger.Printf(" %-5s "+color.CyanString("%s")+" / "+color.CyanString("%s")+" / "+color.CyanString("%s")+"\n",
+			name, fmtMs(ms(p.Min)), fmtMs(ms(p.Total/time.Duration(p.Count))), fmtMs(ms(p.Max)))
+	}
+}
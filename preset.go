@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// configLayer is a named set of flag values that are applied together.
+type configLayer struct {
+	name   string
+	values map[string]string
+}
+
+// netLayers hold the defaults for a --proto that need different ones from
+// TCP: local sockets answer at once or not at all.
+var netLayers = map[string]configLayer{
+	"unix":       {"unix sockets", map[string]string{"w": "1s"}},
+	"unixgram":   {"unix sockets", map[string]string{"w": "1s"}},
+	"unixpacket": {"unix sockets", map[string]string{"w": "1s"}},
+}
+
+// presets bundle the timing, retries and thresholds that suit a kind of
+// network, for --preset.
+var presets = map[string]configLayer{
+	"lan": {"preset lan", map[string]string{
+		"interval": "200ms", "w": "1s", "retries": "0",
+		"spike-threshold": "3x", "spike-ceiling": "20ms",
+		"max-p95": "10ms", "max-loss": "0.1%",
+	}},
+	"wan": {"preset wan", map[string]string{
+		"interval": "1s", "w": "3s", "retries": "1", "retry-delay": "200ms",
+		"spike-threshold": "3x", "spike-ceiling": "300ms",
+		"max-p95": "150ms", "max-loss": "1%",
+	}},
+	"satellite": {"preset satellite", map[string]string{
+		"interval": "2s", "w": "10s", "retries": "2", "retry-delay": "1s",
+		"spike-threshold": "2x", "spike-ceiling": "1500ms",
+		"max-p95": "900ms", "max-loss": "3%",
+	}},
+	"mobile": {"preset mobile", map[string]string{
+		"interval": "1s", "w": "8s", "retries": "2", "retry-delay": "500ms",
+		"spike-threshold": "4x", "spike-ceiling": "800ms",
+		"max-p95": "300ms", "max-loss": "3%",
+	}},
+}
+
+// configLayers returns the layers for --proto and then each preset in
+// --preset, in the order they apply.
+func configLayers() ([]configLayer, error) {
+	var layers []configLayer
+	if l, ok := netLayers[*protoName]; ok {
+		layers = append(layers, l)
+	}
+	if *presetSpec == "" {
+		return layers, nil
+	}
+	for _, name := range strings.Split(*presetSpec, ",") {
+		l, ok := presets[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q, want %s", name, strings.Join(presetNames(), ", "))
+		}
+		layers = append(layers, l)
+	}
+	return layers, nil
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// layerSources names the layer that set each flag in applyLayers.
+var layerSources = make(map[string]string)
+
+// visitCommandLine calls fn for each flag given on the command line. The
+// flag package cannot tell these from flags set by applyLayers, so those
+// are skipped by name.
+func visitCommandLine(fn func(*flag.Flag)) {
+	flag.Visit(func(f *flag.Flag) {
+		if layerSources[f.Name] == "" {
+			fn(f)
+		}
+	})
+}
+
+// applyLayers sets the flags from each layer in turn, so that later layers
+// override earlier ones; flags given on the command line override them
+// all.
+func applyLayers(layers []configLayer) error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, l := range layers {
+		for name, value := range l.values {
+			if explicit[name] {
+				continue
+			}
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %w", l.name, err)
+			}
+			layerSources[name] = l.name
+		}
+	}
+	return nil
+}
+
+// configValue is a flag in the --print-config output.
+type configValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// printResolvedConfig writes every flag as --print-config shows it: its
+// value after the layers and the command line, and which of the default,
+// a layer or the command line it came from. Secrets are redacted as in the
+// run metadata, since the output is likely to be pasted into a bug report.
+func printResolvedConfig(w io.Writer, layers []configLayer) error {
+	set := make(map[string]bool)
+	visitCommandLine(func(f *flag.Flag) { set[f.Name] = true })
+
+	config := struct {
+		Layers []string               `json:"layers"`
+		Flags  map[string]configValue `json:"flags"`
+	}{Layers: []string{}, Flags: make(map[string]configValue)}
+	for _, l := range layers {
+		config.Layers = append(config.Layers, l.name)
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		v := configValue{Value: f.Value.String(), Source: "default"}
+		switch {
+		case layerSources[f.Name] != "":
+			v.Source = layerSources[f.Name]
+		case set[f.Name]:
+			v.Source = "command line"
+		}
+		if secretFlags[f.Name] && v.Value != "" {
+			v.Value = "redacted"
+		} else if f.Name == "proxy-chain" {
+			v.Value = redactProxies(v.Value)
+		}
+		config.Flags[f.Name] = v
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"paping/probe"
+)
+
+func newResult(t *Target) Result {
+	return Result{Time: time.Now(), Target: t.Addr(), Host: t.Host, Port: t.Port, Proto: t.Proto, Seq: int(t.seq.Add(1))}
+}
+
+// ping probes t, retrying up to --retries times with exponential backoff
+// so that only a probe whose every attempt failed counts as lost. Stopping
+// t ends the retries early, and a dial it aborted is neither recorded nor
+// printed.
+func ping(t *Target) Result {
+	initial := newResult(t)
+	r := initial
+	err := probeOnce(t, &r)
+
+	delay := *retryDelay
+	for attempt := 1; err != nil && attempt <= *retries && t.ctx.Err() == nil; attempt++ {
+		t.Stats.recordRetry()
+		diag.Debug(fmt.Sprintf("Retrying %s in %s: %v", t.Addr(), delay, err), "target", t.Addr(), "attempt", attempt+1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-t.ctx.Done():
+			timer.Stop()
+			return finish(t, r, err)
+		case <-timer.C:
+		}
+		delay *= 2
+
+		r = initial
+		r.Attempts = attempt + 1
+		err = probeOnce(t, &r)
+		if err == nil {
+			t.Stats.recordRecovered()
+		}
+	}
+	if errors.Is(err, context.Canceled) && t.ctx.Err() != nil {
+		return r
+	}
+	return finish(t, r, err)
+}
+
+// finish records the outcome of a probe in the target's stats, prints it
+// and hands it to the sinks. A --half-open SYN probe only counts in the
+// split stats, since SYN to SYN-ACK is not a connection time.
+func finish(t *Target, r Result, err error) Result {
+	switch {
+	case err != nil:
+		r.Category = classify(err)
+		r.Error = err.Error()
+		if r.HalfOpen {
+			t.Stats.recordHalfOpenFailure()
+		} else {
+			t.Stats.recordFailure(r.Category, err)
+		}
+	case r.HalfOpen:
+		r.Success = true
+	default:
+		rtt := fromMs(r.RTT)
+		if *resolveEach && r.ISP != "" {
+			if c, changed := t.Stats.recordISP(r); changed {
+				r.PrevISP = c.From
+				logger.Printf(color.YellowString("ISP changed for %s: %s (%s) -> %s (%s)\n", t.Addr(), c.From, c.FromIP, c.To, c.ToIP))
+			}
+		}
+		t.Stats.recordSuccess(rtt, r.ISP)
+		r.Success = true
+		if spikesEnabled() {
+			if spike, base := t.Stats.recordSpike(rtt); spike {
+				r.Spike, r.Baseline = true, ms(base)
+			}
+		}
+		if r.Perceived > 0 {
+			t.Stats.recordPerceived(fromMs(r.Perceived))
+		}
+		if r.WarmRTT > 0 {
+			t.Stats.recordWarm(fromMs(r.FirstRTT), fromMs(r.WarmRTT))
+		}
+		if breakdownEnabled() {
+			t.Stats.recordPhases(r)
+		}
+		if r.Bytes > 0 {
+			t.Stats.recordTransfer(r)
+		}
+		if r.Edge != "" {
+			if prev, switched := t.Stats.recordEdge(r.Edge, rtt); switched {
+				logger.Printf(color.YellowString("Edge changed for %s: %s -> %s\n", t.Addr(), prev, r.Edge))
+			}
+		}
+	}
+	if *sampleLoad {
+		markLoad(&r)
+		if r.Overload && !r.HalfOpen {
+			t.Stats.recordOverload(r)
+		}
+	}
+	printResult(r, err)
+	if *gameMode && !r.HalfOpen {
+		t.Stats.recordGame(r)
+	}
+	downWord, upWord := stateWords()
+	if down, up := t.Stats.observe(r); down != nil {
+		logger.Printf(color.RedString("%s is %s since %s\n", t.Addr(), downWord, down.Start.Format("15:04:05")))
+		if *diagnoseDown {
+			diagnose(t, down.Start)
+		}
+	} else if up != nil {
+		logger.Printf(color.GreenString("%s is %s again after %s\n", t.Addr(), upWord, up.Duration().Round(time.Millisecond)))
+	} else if *showLevel == showChanges && r.Success && t.Stats.firstSuccess() {
+		// Without probe lines a target that never goes down would
+		// otherwise print nothing at all.
+		logger.Printf(color.GreenString("%s is %s\n", t.Addr(), upWord))
+	}
+	emit(r)
+	if r.Spike && *spikeAlert {
+		flushSinks()
+	}
+	return r
+}
+
+func probeOnce(t *Target, r *Result) error {
+	conn, err := connect(t, r)
+	if *expectClosed {
+		if conn != nil {
+			conn.Close()
+		}
+		return invertOutcome(r, err)
+	}
+	if err != nil || conn == nil {
+		return err
+	}
+	defer conn.Close()
+
+	if *warmMode {
+		return warmPings(conn, r)
+	}
+	return nil
+}
+
+// connect resolves, looks up and connects to t, filling in the timing and
+// address fields of r as each step completes. For QUIC it returns no
+// connection, the handshake having closed its own.
+func connect(t *Target, r *Result) (net.Conn, error) {
+	if isUnixNet(t.Proto) {
+		return open(t, t.Host, 0, r)
+	}
+
+	ips, dnsTime, err := resolve(t.Host)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := familyIP(t.Host, ips, t.Proto)
+	if err != nil {
+		return nil, err
+	}
+	r.IP = ip
+	if dnsTime > 0 {
+		r.DNS = float64(dnsTime.Microseconds()) / 1000
+		r.Resolved = ips
+	}
+
+	if geo != nil {
+		ipInfo, err := geo.Lookup(ip)
+		switch {
+		case errors.Is(err, errLookupDisabled):
+		case err != nil:
+			// The ISP only enriches the result; a failed lookup must not
+			// fail the probe.
+			diag.Warn(fmt.Sprintf("ISP lookup for %s failed: %v", ip, err), "target", t.Addr(), "ip", ip)
+		default:
+			r.ISP = ipInfo.Org
+			if infoFields[infoASN] {
+				r.ASN = ipInfo.ASN
+			}
+			if infoFields[infoCountry] {
+				r.Country = ipInfo.Country
+			}
+		}
+	}
+	if *rdnsMode {
+		r.RDNS = reverseName(ip)
+	}
+
+	// With --half-open every other probe stops at the SYN-ACK.
+	if *halfOpen && r.Seq%2 == 0 {
+		r.HalfOpen = true
+		local, err := localAddr(*ifaceName)
+		if err != nil {
+			return nil, err
+		}
+		took, err := synProbe(local, ip, t.Port, *probeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		r.RTT = ms(took)
+		t.Stats.recordSplit(true, took)
+		return nil, nil
+	}
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(t.Port))
+	if t.Proto == protoQUIC {
+		local, err := localAddr(*ifaceName)
+		if err != nil {
+			return nil, err
+		}
+		took, alpn, laddr, err := quicHandshake(local, addr, t.Host)
+		if err != nil {
+			return nil, err
+		}
+		r.RTT, r.ALPN, r.Local = ms(took), alpn, laddr
+		if *userPerceived {
+			r.Perceived = ms(dnsTime + took)
+		}
+		return nil, nil
+	}
+
+	return open(t, addr, dnsTime, r)
+}
+
+// open dials addr for connect, reads what --banner and the --edge-* flags
+// ask for and runs the --check exchange; dnsTime is how long resolving the
+// target took.
+func open(t *Target, addr string, dnsTime time.Duration, r *Result) (net.Conn, error) {
+	conn, took, err := dial(t, addr, r)
+	if err != nil {
+		return nil, err
+	}
+	if *userPerceived {
+		r.Perceived = ms(dnsTime + took)
+	}
+	if *halfOpen {
+		t.Stats.recordSplit(false, took)
+	}
+
+	if *bannerMode && !t.Stats.hasBanner() {
+		if banner := readBanner(conn, *bannerSize); banner != "" && t.Stats.setBanner(banner) {
+			r.Banner = banner
+			logger.Printf("Banner from "+color.CyanString("%s")+": %s\n", t.Addr(), banner)
+		}
+	}
+	// Without --breakdown the layers only serve to name the edge, so a
+	// failure there does not fail the probe.
+	if edgeEnabled() || breakdownEnabled() {
+		if err := upperLayers(conn, t.Host, r); err != nil && breakdownEnabled() {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if *checkName != "" {
+		if err := runCheck(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// dial connects to addr over the network of t, filling in the connection
+// time and local address of r.
+func dial(t *Target, addr string, r *Result) (net.Conn, time.Duration, error) {
+	conn, took, err := t.Dialer.Dial(t.ctx, t.Proto, addr)
+	if err != nil {
+		if *expectClosed {
+			r.RTT = ms(took)
+		}
+		return nil, 0, err
+	}
+	r.RTT = ms(took)
+	r.Local = conn.LocalAddr().String()
+	if *verbose {
+		if srtt, rttvar, retrans, ok := kernelTCPInfo(conn); ok {
+			r.SRTT, r.RTTVar, r.Retrans = ms(srtt), ms(rttvar), retrans
+		}
+	}
+
+	if pc, ok := conn.(*probe.ProxyConn); ok {
+		for _, leg := range pc.Legs {
+			r.ProxyLegs = append(r.ProxyLegs, ms(leg))
+		}
+	}
+	return conn, took, nil
+}
@@ -0,0 +1,69 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DialContextFunc has the signature of net.Dialer.DialContext.
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dialer opens the connections that probes time. Embedders can set
+// DialContext to route probes through their own transport, such as an
+// in-process SOCKS implementation or a simulated test network.
+type Dialer struct {
+	// DialContext replaces a plain net.Dialer when set.
+	DialContext DialContextFunc
+	// Timeout bounds each connection attempt; zero means no limit.
+	Timeout time.Duration
+}
+
+// Dial connects to addr and reports how long the connection took to set up,
+// or on failure how long it took to fail.
+func (d *Dialer) Dial(ctx context.Context, network, addr string) (net.Conn, time.Duration, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	dial := d.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	start := time.Now()
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	return conn, time.Since(start), nil
+}
+
+// LocalDialer returns a DialContextFunc that dials directly from the given
+// local address, or from any address when it is nil. A TCP local address
+// is used as the matching UDP one when dialing over UDP.
+func LocalDialer(local net.Addr) DialContextFunc {
+	return ControlDialer(local, nil)
+}
+
+// ControlDialer is LocalDialer with control, when not nil, called on each
+// socket before it connects, as with net.Dialer.Control; it can set
+// socket options such as the TOS byte or TTL.
+func ControlDialer(local net.Addr, control func(network, address string, c syscall.RawConn) error) DialContextFunc {
+	d := &net.Dialer{LocalAddr: local, Control: control}
+	tcp, ok := local.(*net.TCPAddr)
+	if !ok {
+		return d.DialContext
+	}
+	u := &net.Dialer{LocalAddr: &net.UDPAddr{IP: tcp.IP, Zone: tcp.Zone}, Control: control}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if strings.HasPrefix(network, "udp") {
+			return u.DialContext(ctx, network, addr)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
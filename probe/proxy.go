@@ -0,0 +1,397 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyHop is one SOCKS5 or HTTP CONNECT proxy in a chain.
+type ProxyHop struct {
+	Scheme string
+	Addr   string
+	User   *url.Userinfo
+}
+
+// ParseProxyChain parses a comma-separated list of proxy URLs such as
+// "socks5://a:1080,http://user:pass@b:3128".
+func ParseProxyChain(s string) ([]ProxyHop, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var chain []ProxyHop
+	for _, part := range strings.Split(s, ",") {
+		u, err := url.Parse(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", part, err)
+		}
+		switch u.Scheme {
+		case "socks5", "http":
+		default:
+			return nil, fmt.Errorf("invalid proxy %q: scheme must be socks5 or http", part)
+		}
+		if u.Port() == "" {
+			return nil, fmt.Errorf("invalid proxy %q: missing port", part)
+		}
+		chain = append(chain, ProxyHop{Scheme: u.Scheme, Addr: u.Host, User: u.User})
+	}
+	return chain, nil
+}
+
+// ProxyConn is a connection made through a proxy chain. Legs times each
+// leg: the connect to the first proxy, each tunnel through to the next
+// proxy, and finally the tunnel to the target.
+type ProxyConn struct {
+	net.Conn
+	Legs []time.Duration
+}
+
+// ProxyChain returns a DialContextFunc that reaches addr through every
+// proxy in chain in turn, using base to connect to the first one.
+func ProxyChain(chain []ProxyHop, base DialContextFunc) DialContextFunc {
+	if base == nil {
+		base = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		legs := make([]time.Duration, 0, len(chain)+1)
+
+		start := time.Now()
+		conn, err := base(ctx, network, chain[0].Addr)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", chain[0].Addr, err)
+		}
+		legs = append(legs, time.Since(start))
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+
+		for i, hop := range chain {
+			next := addr
+			if i+1 < len(chain) {
+				next = chain[i+1].Addr
+			}
+
+			start = time.Now()
+			conn, err = tunnel(conn, hop, next)
+			if err != nil {
+				return nil, fmt.Errorf("proxy %s: %w", hop.Addr, err)
+			}
+			legs = append(legs, time.Since(start))
+		}
+
+		conn.SetDeadline(time.Time{})
+		return &ProxyConn{Conn: conn, Legs: legs}, nil
+	}
+}
+
+func tunnel(conn net.Conn, hop ProxyHop, addr string) (net.Conn, error) {
+	var err error
+	if hop.Scheme == "http" {
+		conn, err = httpConnect(conn, hop, addr)
+	} else {
+		err = socks5Connect(conn, hop, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func httpConnect(conn net.Conn, hop ProxyHop, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if hop.User != nil {
+		pass, _ := hop.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(hop.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+var socks5Errors = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func socks5Connect(conn net.Conn, hop ProxyHop, addr string) error {
+	if err := socks5Auth(conn, hop); err != nil {
+		return err
+	}
+	_, err := socks5Request(conn, socks5CmdConnect, addr)
+	return err
+}
+
+const (
+	socks5CmdConnect      = 0x01
+	socks5CmdUDPAssociate = 0x03
+)
+
+// socks5Auth negotiates no authentication, or username and password when
+// hop has them.
+func socks5Auth(conn net.Conn, hop ProxyHop) error {
+	method := byte(0x00)
+	if hop.User != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[0] != 0x05 || buf[1] != method {
+		return errors.New("SOCKS5 authentication method rejected")
+	}
+
+	if method == 0x02 {
+		user := hop.User.Username()
+		pass, _ := hop.User.Password()
+		msg := append([]byte{0x01, byte(len(user))}, user...)
+		msg = append(append(msg, byte(len(pass))), pass...)
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if buf[1] != 0x00 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	}
+	return nil
+}
+
+// socks5Request sends cmd for addr and returns the address the proxy
+// bound for it.
+func socks5Request(conn net.Conn, cmd byte, addr string) (string, error) {
+	dst, err := socks5Addr(addr)
+	if err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(append([]byte{0x05, cmd, 0x00}, dst...)); err != nil {
+		return "", err
+	}
+
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return "", err
+	}
+	if head[1] != 0x00 {
+		name := "CONNECT"
+		if cmd == socks5CmdUDPAssociate {
+			name = "UDP ASSOCIATE"
+		}
+		if msg, ok := socks5Errors[head[1]]; ok {
+			return "", fmt.Errorf("SOCKS5 %s %s: %s", name, addr, msg)
+		}
+		return "", fmt.Errorf("SOCKS5 %s %s: error %d", name, addr, head[1])
+	}
+	return readSOCKS5Addr(conn)
+}
+
+// socks5Addr encodes addr as ATYP, DST.ADDR and DST.PORT.
+func socks5Addr(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	if ip := net.ParseIP(host); ip == nil {
+		b = append([]byte{0x03, byte(len(host))}, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append([]byte{0x01}, ip4...)
+	} else {
+		b = append([]byte{0x04}, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// readSOCKS5Addr reads an ATYP, ADDR and PORT triple.
+func readSOCKS5Addr(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case 0x01, 0x04:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == 0x04 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case 0x03:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(r, n); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("SOCKS5 reply with unknown address type %d", atyp[0])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// DialSOCKS5UDP sets up a UDP ASSOCIATE with the SOCKS5 proxy hop and
+// returns a connection whose writes go to addr through the proxy's relay
+// and whose reads return what addr sends back. base dials both the TCP
+// control connection, which is kept open as long as the association is
+// needed, and the UDP socket to the relay.
+func DialSOCKS5UDP(ctx context.Context, hop ProxyHop, base DialContextFunc, addr string) (net.Conn, error) {
+	if hop.Scheme != "socks5" {
+		return nil, fmt.Errorf("proxy %s: UDP needs a socks5 proxy", hop.Addr)
+	}
+	if base == nil {
+		base = (&net.Dialer{}).DialContext
+	}
+	header, err := socks5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl, err := base(ctx, "tcp", hop.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", hop.Addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		ctrl.SetDeadline(deadline)
+	}
+	relay, err := socks5Associate(ctrl, hop)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("proxy %s: %w", hop.Addr, err)
+	}
+	ctrl.SetDeadline(time.Time{})
+
+	conn, err := base(ctx, "udp", relay)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("proxy %s relay %s: %w", hop.Addr, relay, err)
+	}
+	return &socks5UDPConn{Conn: conn, ctrl: ctrl, header: append([]byte{0, 0, 0}, header...)}, nil
+}
+
+// socks5Associate asks for a UDP relay and returns its address, which
+// proxies commonly report as 0.0.0.0 meaning their own address.
+func socks5Associate(ctrl net.Conn, hop ProxyHop) (string, error) {
+	if err := socks5Auth(ctrl, hop); err != nil {
+		return "", err
+	}
+	// The client's own address is not known before the socket is open,
+	// which RFC 1928 allows to be sent as all zeros.
+	relay, err := socks5Request(ctrl, socks5CmdUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		return "", err
+	}
+	host, port, err := net.SplitHostPort(relay)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host, _, _ = net.SplitHostPort(ctrl.RemoteAddr().String())
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// socks5UDPConn wraps datagrams in the SOCKS5 UDP request header on the
+// way out and strips it on the way in.
+type socks5UDPConn struct {
+	net.Conn
+	ctrl   net.Conn
+	header []byte
+}
+
+func (c *socks5UDPConn) Write(p []byte) (int, error) {
+	if _, err := c.Conn.Write(append(append([]byte(nil), c.header...), p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *socks5UDPConn) Read(p []byte) (int, error) {
+	buf := make([]byte, len(p)+262)
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		// Skip RSV and FRAG, dropping fragments, which are rarely used
+		// and need not be supported, then the source address.
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		if _, err := readSOCKS5Addr(r); err != nil {
+			continue
+		}
+		return r.Read(p)
+	}
+}
+
+func (c *socks5UDPConn) Close() error {
+	err := c.Conn.Close()
+	c.ctrl.Close()
+	return err
+}
@@ -0,0 +1,90 @@
+// Package probe holds the types paping shares with code that embeds it or
+// extends it with custom output sinks.
+package probe
+
+import "time"
+
+// Result is the outcome of a single probe.
+type Result struct {
+	Time   time.Time `json:"time"`
+	Target string    `json:"target"`
+	Host   string    `json:"host"`
+	Port   int       `json:"port"`
+	// IP is the address probed.
+	IP string `json:"ip,omitempty"`
+	// Resolved lists every address the host name resolved to.
+	Resolved []string `json:"resolved,omitempty"`
+	// Local is the local end of the connection.
+	Local   string `json:"local,omitempty"`
+	Proto   string `json:"proto"`
+	Seq     int    `json:"seq"`
+	Success bool   `json:"success"`
+	// Attempts is only set when the probe was retried.
+	Attempts int `json:"attempts,omitempty"`
+	// Reused is set for --keepalive round trips on an already open
+	// connection.
+	Reused bool `json:"reused,omitempty"`
+	// HalfOpen marks a --half-open probe that timed SYN to SYN-ACK only.
+	HalfOpen bool    `json:"half_open,omitempty"`
+	DNS      float64 `json:"dns_ms,omitempty"`
+	RTT      float64 `json:"rtt_ms,omitempty"`
+	// TLS and HTTP time the handshake and the HEAD request that
+	// --breakdown adds after connecting.
+	TLS  float64 `json:"tls_ms,omitempty"`
+	HTTP float64 `json:"http_ms,omitempty"`
+	// Bytes is how much --probe-size moved on the probe.
+	Bytes int64 `json:"bytes,omitempty"`
+	// Bitrate is the throughput of that transfer, in bits per second.
+	Bitrate float64 `json:"throughput_bps,omitempty"`
+	// SRTT and RTTVar are the kernel's smoothed round trip and its
+	// variation, and Retrans the segments it retransmitted, SYNs
+	// included, read after connecting with -v on Linux.
+	SRTT    float64 `json:"srtt_ms,omitempty"`
+	RTTVar  float64 `json:"rttvar_ms,omitempty"`
+	Retrans int     `json:"retrans,omitempty"`
+	// Perceived is the cold DNS plus connect time measured by
+	// --user-perceived.
+	Perceived float64 `json:"perceived_ms,omitempty"`
+	// Spike marks a probe over the --spike-threshold or --spike-ceiling.
+	Spike bool `json:"spike,omitempty"`
+	// Baseline is the moving average connection time Spike was judged
+	// against.
+	Baseline float64 `json:"baseline_ms,omitempty"`
+	// ProxyLegs times each leg of a --proxy-chain connection, ending with
+	// the tunnel to the target.
+	ProxyLegs []float64 `json:"proxy_legs_ms,omitempty"`
+	// FirstRTT and WarmRTT are the two application pings sent in --warm
+	// mode.
+	FirstRTT float64 `json:"first_rtt_ms,omitempty"`
+	WarmRTT  float64 `json:"warm_rtt_ms,omitempty"`
+	ISP      string  `json:"isp,omitempty"`
+	// PrevISP is set on the probe where --resolve-each saw the address
+	// move to another ISP.
+	PrevISP string `json:"prev_isp,omitempty"`
+	// ASN and Country come with ISP when --info-fields asks for them.
+	ASN     string `json:"asn,omitempty"`
+	Country string `json:"country,omitempty"`
+	// RDNS is the PTR name of IP with --rdns.
+	RDNS string `json:"rdns,omitempty"`
+	// ALPN is the application protocol negotiated by a QUIC handshake.
+	ALPN string `json:"alpn,omitempty"`
+	// Edge is the edge or PoP that answered the --edge-* probes.
+	Edge string `json:"edge,omitempty"`
+	// Banner is only filled in on the probe that first captured it.
+	Banner string `json:"banner,omitempty"`
+	// Closed is set on the successes of --expect-closed to how the
+	// connection was turned away: refused, timeout or unreachable, with
+	// RTT how long that took.
+	Closed string `json:"closed,omitempty"`
+	// CPU and NICDrops are this machine's CPU use and dropped packets
+	// sampled with --sample-load around the time of the probe.
+	CPU      float64 `json:"cpu_pct,omitempty"`
+	NICDrops uint64  `json:"nic_drops,omitempty"`
+	// Overload flags a probe taken while CPU or NICDrops was high enough
+	// to delay it here rather than on the network.
+	Overload bool `json:"overloaded,omitempty"`
+	// Category classifies a failure as timeout, refused, unreachable,
+	// dns, dropped, open, protocol or error.
+	Category string `json:"category,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,66 @@
+package probe
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Sink receives every probe result. Write is called from many goroutines
+// but never concurrently with Flush or Close; Flush should push out
+// anything buffered, and Close is called once when paping exits.
+type Sink interface {
+	Write(Result) error
+	Flush() error
+	Close() error
+}
+
+// SinkFactory creates a sink from the text after "name:" in a --sink flag.
+type SinkFactory func(config string) (Sink, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]SinkFactory)
+)
+
+// RegisterSink makes a sink available to --sink under name. It is meant to
+// be called from an init function, so a custom sink can be compiled in by
+// importing its package for side effects. It panics if name is already
+// registered or factory is nil.
+func RegisterSink(name string, factory SinkFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("probe: RegisterSink factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("probe: RegisterSink called twice for sink " + name)
+	}
+	factories[name] = factory
+}
+
+// NewSink creates a sink using the factory registered under name.
+func NewSink(name, config string) (Sink, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q", name)
+	}
+	return factory(config)
+}
+
+// Sinks returns the names of all registered sinks, sorted.
+func Sinks() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,119 @@
+package probe
+
+import (
+	"sync"
+	"time"
+)
+
+// Snapshot is a copy of a target's counters at one moment. It shares no
+// memory with the stats it was taken from, so it can be kept and read
+// without locking.
+type Snapshot struct {
+	Time      time.Time
+	Attempted int
+	Connected int
+	Failed    int
+	// TotalTime is the sum of the connection times of successful probes.
+	TotalTime time.Duration
+	// MinTime and MaxTime cover the whole run, even in a Delta, since
+	// they cannot be recovered by subtraction.
+	MinTime time.Duration
+	MaxTime time.Duration
+	// Failures counts failed probes by category.
+	Failures map[string]int
+}
+
+// Loss returns the percentage of attempted probes that failed.
+func (s Snapshot) Loss() float64 {
+	if s.Attempted == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Attempted) * 100
+}
+
+// Average returns the mean connection time of the successful probes.
+func (s Snapshot) Average() time.Duration {
+	if s.Connected == 0 {
+		return 0
+	}
+	return s.TotalTime / time.Duration(s.Connected)
+}
+
+// Delta returns what happened between prev and s, both taken from the
+// same stats with prev first: the counts, total time and failures of the
+// probes in that interval.
+func (s Snapshot) Delta(prev Snapshot) Snapshot {
+	d := Snapshot{
+		Time:      s.Time,
+		Attempted: s.Attempted - prev.Attempted,
+		Connected: s.Connected - prev.Connected,
+		Failed:    s.Failed - prev.Failed,
+		TotalTime: s.TotalTime - prev.TotalTime,
+		MinTime:   s.MinTime,
+		MaxTime:   s.MaxTime,
+	}
+	for category, n := range s.Failures {
+		if n -= prev.Failures[category]; n > 0 {
+			if d.Failures == nil {
+				d.Failures = make(map[string]int)
+			}
+			d.Failures[category] = n
+		}
+	}
+	return d
+}
+
+// Stats accumulates results for embedders that run probes themselves. It
+// is safe for concurrent use.
+type Stats struct {
+	mu sync.Mutex
+	s  Snapshot
+}
+
+// Record adds r to the stats.
+func (st *Stats) Record(r Result) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.Attempted++
+	if !r.Success {
+		st.s.Failed++
+		if st.s.Failures == nil {
+			st.s.Failures = make(map[string]int)
+		}
+		st.s.Failures[r.Category]++
+		return
+	}
+	rtt := time.Duration(r.RTT * float64(time.Millisecond))
+	st.s.Connected++
+	st.s.TotalTime += rtt
+	if st.s.MinTime == 0 || rtt < st.s.MinTime {
+		st.s.MinTime = rtt
+	}
+	if rtt > st.s.MaxTime {
+		st.s.MaxTime = rtt
+	}
+}
+
+// Snapshot returns a copy of the stats as of now.
+func (st *Stats) Snapshot() Snapshot {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s := st.s
+	s.Time = time.Now()
+	s.Failures = copyFailures(s.Failures)
+	return s
+}
+
+// copyFailures returns a copy of a failure count map, or nil for nil.
+func copyFailures(failures map[string]int) map[string]int {
+	if failures == nil {
+		return nil
+	}
+	c := make(map[string]int, len(failures))
+	for category, n := range failures {
+		c[category] = n
+	}
+	return c
+}
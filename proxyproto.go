@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	"paping/probe"
+)
+
+// proxyV2Signature starts every PROXY protocol version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+func checkProxyProtocol(version string) error {
+	switch version {
+	case "", "v1", "v2":
+		return nil
+	}
+	return fmt.Errorf("invalid --proxy-protocol %q, want v1 or v2", version)
+}
+
+// withProxyHeader wraps dial so that every TCP connection it makes starts
+// with a PROXY protocol header naming the local end as the client and addr
+// as the destination, as HAProxy and similar load balancers send to their
+// backends. Backends that require the header reset connections without
+// it, which would otherwise look like the target being down.
+func withProxyHeader(dial probe.DialContextFunc, version string) probe.DialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil || network != "tcp" {
+			return conn, err
+		}
+		header, err := proxyHeader(version, conn.LocalAddr(), addr)
+		if err == nil {
+			_, err = conn.Write(header)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("PROXY header: %w", err)
+		}
+		return conn, nil
+	}
+}
+
+// proxyHeader builds a version 1 (text) or 2 (binary) PROXY header for a
+// TCP connection from src to dst. Mixed address families are sent as IPv6,
+// with the IPv4 address mapped.
+func proxyHeader(version string, src net.Addr, dst string) ([]byte, error) {
+	srcTCP, ok := src.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("local address %v is not TCP", src)
+	}
+	host, portStr, err := net.SplitHostPort(dst)
+	if err != nil {
+		return nil, err
+	}
+	dstIP := net.ParseIP(host)
+	if dstIP == nil {
+		return nil, fmt.Errorf("destination %q is not an IP address", host)
+	}
+	dstPort, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	srcIP := srcTCP.IP
+	v4 := srcIP.To4() != nil && dstIP.To4() != nil
+	if v4 {
+		srcIP, dstIP = srcIP.To4(), dstIP.To4()
+	} else {
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	}
+
+	if version == "v1" {
+		if v4 {
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", srcIP, dstIP, srcTCP.Port, dstPort)), nil
+		}
+		return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ipv6Text(srcIP), ipv6Text(dstIP), srcTCP.Port, dstPort)), nil
+	}
+
+	// Version 2 with the PROXY command over TCP (stream) on IPv4 or IPv6.
+	family := byte(0x21)
+	if v4 {
+		family = 0x11
+	}
+	addrs := append(append([]byte(nil), srcIP...), dstIP...)
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(srcTCP.Port))
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(dstPort))
+
+	header := append(append([]byte(nil), proxyV2Signature...), 0x21, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...), nil
+}
+
+// ipv6Text writes ip as IPv6 even when it is a mapped IPv4 address, which
+// net.IP prints in dotted form.
+func ipv6Text(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return "::ffff:" + v4.String()
+	}
+	return ip.String()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestProxyHeaderV1(t *testing.T) {
+	tests := []struct {
+		name string
+		src  *net.TCPAddr
+		dst  string
+		want string
+	}{
+		{"IPv4", &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}, "192.0.2.1:80", "PROXY TCP4 10.0.0.1 192.0.2.1 50000 80\r\n"},
+		{"IPv6", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000}, "[2001:db8::2]:443", "PROXY TCP6 2001:db8::1 2001:db8::2 50000 443\r\n"},
+		{"mixed", &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}, "[2001:db8::2]:443", "PROXY TCP6 ::ffff:10.0.0.1 2001:db8::2 50000 443\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := proxyHeader("v1", tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("header = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHeaderV2(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    *net.TCPAddr
+		dst    string
+		family byte
+		addrs  []byte
+	}{
+		{
+			"IPv4",
+			&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000},
+			"192.0.2.1:80",
+			0x11,
+			[]byte{10, 0, 0, 1, 192, 0, 2, 1, 0xc3, 0x50, 0, 80},
+		},
+		{
+			"IPv6",
+			&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000},
+			"[2001:db8::2]:443",
+			0x21,
+			append(append(append([]byte(nil), net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0xc3, 0x50, 0x01, 0xbb),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := proxyHeader("v2", tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append([]byte(nil), proxyV2Signature...)
+			want = append(want, 0x21, tt.family)
+			want = binary.BigEndian.AppendUint16(want, uint16(len(tt.addrs)))
+			want = append(want, tt.addrs...)
+			if !bytes.Equal(got, want) {
+				t.Errorf("header = % x, want % x", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyHeaderErrors(t *testing.T) {
+	tcp := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}
+	tests := []struct {
+		name string
+		src  net.Addr
+		dst  string
+	}{
+		{"UDP source", &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}, "192.0.2.1:80"},
+		{"host name", tcp, "example.com:80"},
+		{"no port", tcp, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		for _, version := range []string{"v1", "v2"} {
+			if _, err := proxyHeader(version, tt.src, tt.dst); err == nil {
+				t.Errorf("%s %s: no error", tt.name, version)
+			}
+		}
+	}
+}
+
+func TestCheckProxyProtocol(t *testing.T) {
+	for _, v := range []string{"", "v1", "v2"} {
+		if err := checkProxyProtocol(v); err != nil {
+			t.Errorf("checkProxyProtocol(%q) = %v", v, err)
+		}
+	}
+	if checkProxyProtocol("v3") == nil {
+		t.Error("checkProxyProtocol(\"v3\") accepted")
+	}
+}
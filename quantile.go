@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	estimatorReservoir = "reservoir"
+	estimatorHistogram = "histogram"
+)
+
+// quantileEstimator tracks connection times for percentile reporting in
+// bounded memory, however long the run.
+type quantileEstimator interface {
+	Add(d time.Duration)
+	Quantile(q float64) time.Duration
+	Count() int
+}
+
+func checkEstimator(name string, cap int) error {
+	switch name {
+	case estimatorReservoir, estimatorHistogram:
+	default:
+		return fmt.Errorf("invalid estimator %q, want reservoir or histogram", name)
+	}
+	if cap < 1 {
+		return fmt.Errorf("--sample-cap must be at least 1")
+	}
+	return nil
+}
+
+func newEstimator() quantileEstimator {
+	if *estimatorName == estimatorHistogram {
+		return &histogram{}
+	}
+	return newReservoir(*sampleCap)
+}
+
+// reservoir keeps a uniform random sample of at most cap values (Vitter's
+// algorithm R), so percentiles are exact until cap samples have been seen.
+type reservoir struct {
+	cap     int
+	seen    int
+	samples []time.Duration
+	rng     *rand.Rand
+}
+
+func newReservoir(cap int) *reservoir {
+	return &reservoir{cap: cap, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (r *reservoir) Add(d time.Duration) {
+	r.seen++
+	if len(r.samples) < r.cap {
+		r.samples = append(r.samples, d)
+		return
+	}
+	if i := r.rng.Intn(r.seen); i < r.cap {
+		r.samples[i] = d
+	}
+}
+
+func (r *reservoir) Quantile(q float64) time.Duration {
+	if len(r.samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+func (r *reservoir) Count() int { return r.seen }
+
+const (
+	histogramGrowth  = 1.02
+	histogramBuckets = 1200
+)
+
+// histogram counts values in log-spaced buckets 2% wide from 1µs up to
+// about an hour, giving percentiles within 1% in fixed memory.
+type histogram struct {
+	counts [histogramBuckets]uint64
+	total  int
+}
+
+func (h *histogram) Add(d time.Duration) {
+	us := float64(d.Microseconds())
+	idx := 0
+	if us > 1 {
+		idx = int(math.Log(us) / math.Log(histogramGrowth))
+	}
+	if idx >= histogramBuckets {
+		idx = histogramBuckets - 1
+	}
+	h.counts[idx]++
+	h.total++
+}
+
+func (h *histogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var cum uint64
+	for i, c := range h.counts {
+		cum += c
+		if cum >= rank {
+			mid := math.Pow(histogramGrowth, float64(i)+0.5)
+			return time.Duration(mid * float64(time.Microsecond))
+		}
+	}
+	return 0
+}
+
+func (h *histogram) Count() int { return h.total }
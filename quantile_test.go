@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestReservoirExactBelowCap(t *testing.T) {
+	r := newReservoir(1000)
+	// Added in reverse so that Quantile has to sort.
+	for i := 100; i >= 1; i-- {
+		r.Add(time.Duration(i) * time.Millisecond)
+	}
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, 1 * time.Millisecond},
+		{0.5, 50 * time.Millisecond},
+		{0.9, 90 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := r.Quantile(tt.q); got != tt.want {
+			t.Errorf("Quantile(%v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+	if r.Count() != 100 {
+		t.Errorf("Count() = %d, want 100", r.Count())
+	}
+}
+
+func TestReservoirKeepsCap(t *testing.T) {
+	r := newReservoir(10)
+	for i := 1; i <= 1000; i++ {
+		r.Add(time.Duration(i) * time.Millisecond)
+	}
+	if len(r.samples) != 10 {
+		t.Errorf("kept %d samples, want 10", len(r.samples))
+	}
+	if r.Count() != 1000 {
+		t.Errorf("Count() = %d, want 1000", r.Count())
+	}
+	for _, d := range r.samples {
+		if d < time.Millisecond || d > time.Second {
+			t.Errorf("sample %v was never added", d)
+		}
+	}
+}
+
+func TestHistogramWithinBucketWidth(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 1000; i++ {
+		h.Add(time.Duration(i) * time.Millisecond)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+		want := q * float64(time.Second)
+		got := float64(h.Quantile(q))
+		if math.Abs(got-want)/want > histogramGrowth-1 {
+			t.Errorf("Quantile(%v) = %v, want within 2%% of %v", q, time.Duration(got), time.Duration(want))
+		}
+	}
+	if h.Count() != 1000 {
+		t.Errorf("Count() = %d, want 1000", h.Count())
+	}
+}
+
+func TestHistogramClampsOutOfRange(t *testing.T) {
+	var h histogram
+	h.Add(0)
+	h.Add(10 * time.Hour)
+	if got := h.Quantile(0.5); got > 2*time.Microsecond {
+		t.Errorf("Quantile(0.5) = %v, want the first bucket", got)
+	}
+	if got := h.Quantile(1); got < 50*time.Minute {
+		t.Errorf("Quantile(1) = %v, want the last bucket", got)
+	}
+}
+
+func TestEmptyEstimators(t *testing.T) {
+	for name, e := range map[string]quantileEstimator{
+		estimatorReservoir: newReservoir(10),
+		estimatorHistogram: &histogram{},
+	} {
+		if got := e.Quantile(0.5); got != 0 {
+			t.Errorf("%s: Quantile(0.5) = %v with no samples, want 0", name, got)
+		}
+	}
+}
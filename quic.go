@@ -0,0 +1,54 @@
+//go:build quic
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const quicSupported = true
+
+// quicHandshake opens a QUIC connection to addr from local, if set, and
+// returns how long the handshake took, the negotiated ALPN and the local
+// address, closing the connection straight away. Certificates are not
+// verified, as with --edge-tls, since only reachability is measured.
+func quicHandshake(local net.Addr, addr, serverName string) (took time.Duration, alpn, localAddr string, err error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return 0, "", "", err
+	}
+	laddr := &net.UDPAddr{}
+	if tcp, ok := local.(*net.TCPAddr); ok {
+		laddr.IP = tcp.IP
+	}
+	pc, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer pc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *probeTimeout)
+	defer cancel()
+
+	tlsConf := &tls.Config{
+		ServerName:         serverName,
+		NextProtos:         strings.Split(*quicALPN, ","),
+		InsecureSkipVerify: true,
+	}
+	start := time.Now()
+	conn, err := quic.Dial(ctx, pc, raddr, tlsConf, &quic.Config{HandshakeIdleTimeout: *probeTimeout})
+	if err != nil {
+		return 0, "", "", err
+	}
+	took = time.Since(start)
+	alpn = conn.ConnectionState().TLS.NegotiatedProtocol
+	localAddr = conn.LocalAddr().String()
+	conn.CloseWithError(0, "")
+	return took, alpn, localAddr, nil
+}
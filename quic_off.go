@@ -0,0 +1,15 @@
+//go:build !quic
+
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+const quicSupported = false
+
+func quicHandshake(local net.Addr, addr, serverName string) (time.Duration, string, string, error) {
+	return 0, "", "", errors.New("this build has no QUIC support; rebuild with -tags quic")
+}
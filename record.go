@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Changes between two sessions that paping diff counts as regressions:
+// loss up by this many percentage points, or a latency up by this share
+// and by at least diffLatencyFloor, so that jitter on fast links does not
+// count. Any further outage is a regression.
+const (
+	diffLossPoints   = 1.0
+	diffLatencyShare = 0.2
+	diffLatencyFloor = 5 * time.Millisecond
+)
+
+// session is what paping record saves: the run and a summary of each
+// target, enough to compare against a later session.
+type session struct {
+	Run     RunMeta         `json:"run"`
+	Targets []sessionTarget `json:"targets"`
+}
+
+type sessionTarget struct {
+	targetStats
+	Outages     int     `json:"outages"`
+	DowntimePct float64 `json:"downtime_pct"`
+}
+
+func newSession(targets []*Target) session {
+	s := session{Run: currentRun}
+	now := time.Now()
+	for _, t := range targets {
+		st := sessionTarget{targetStats: statsOf(t)}
+		t.Stats.Lock()
+		outages, _, pct := t.Stats.outageSummary(now)
+		t.Stats.Unlock()
+		st.Outages, st.DowntimePct = len(outages), pct
+		s.Targets = append(s.Targets, st)
+	}
+	return s
+}
+
+func writeSession(path string, s session) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func loadSession(path string) (session, error) {
+	var s session
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// diffSessions prints how each target fared in after compared with before,
+// marking regressions in red and improvements in green, and reports
+// whether there was any: more loss, higher latency or more outages.
+func diffSessions(before, after session, beforeName, afterName string) bool {
+	logger.Printf("Before: %s, %s on %s\n", beforeName, before.Run.Start.Format("2006-01-02 15:04:05"), before.Run.Host)
+	logger.Printf("After:  %s, %s on %s\n", afterName, after.Run.Start.Format("2006-01-02 15:04:05"), after.Run.Host)
+
+	old := make(map[string]sessionTarget)
+	for _, t := range before.Targets {
+		old[t.Target] = t
+	}
+	regressed := false
+	for _, a := range after.Targets {
+		b, ok := old[a.Target]
+		if !ok {
+			logger.Printf("\n%s: only in %s\n", color.CyanString(a.Target), afterName)
+			continue
+		}
+		delete(old, a.Target)
+
+		logger.Printf("\n%s:\n", color.CyanString(a.Target))
+		worse := a.LossPct-b.LossPct >= diffLossPoints
+		diffLine("Loss", fmt.Sprintf("%.2f%%", b.LossPct), fmt.Sprintf("%.2f%%", a.LossPct), fmt.Sprintf("%+.2f points", a.LossPct-b.LossPct), worse, b.LossPct-a.LossPct >= diffLossPoints)
+		regressed = regressed || worse
+		// Without connections on both sides there is no latency to compare.
+		if a.Connected > 0 && b.Connected > 0 {
+			for _, m := range []struct {
+				name          string
+				before, after float64
+			}{
+				{"Average", b.AvgMs, a.AvgMs},
+				{"p95", b.P95Ms, a.P95Ms},
+				{"p99", b.P99Ms, a.P99Ms},
+			} {
+				up, down := latencyChange(m.before, m.after)
+				diffLine(m.name, fmtMs(m.before), fmtMs(m.after), fmtMsDelta(m.after-m.before), up, down)
+				regressed = regressed || up
+			}
+		}
+		worse = a.Outages > b.Outages
+		diffLine("Outages", fmt.Sprint(b.Outages), fmt.Sprint(a.Outages), fmt.Sprintf("%+d", a.Outages-b.Outages), worse, a.Outages < b.Outages)
+		regressed = regressed || worse
+		diffLine("Downtime", fmt.Sprintf("%.2f%%", b.DowntimePct), fmt.Sprintf("%.2f%%", a.DowntimePct), fmt.Sprintf("%+.2f points", a.DowntimePct-b.DowntimePct), false, false)
+	}
+	for _, b := range before.Targets {
+		if _, ok := old[b.Target]; ok {
+			logger.Printf("\n%s: only in %s\n", color.CyanString(b.Target), beforeName)
+		}
+	}
+
+	if regressed {
+		logger.Printf("\n%s\n", color.RedString("Regressed"))
+	} else {
+		logger.Printf("\n%s\n", color.GreenString("No regressions"))
+	}
+	return regressed
+}
+
+// latencyChange reports whether going from before to after, in
+// milliseconds, is a notable rise or fall.
+func latencyChange(before, after float64) (up, down bool) {
+	d := after - before
+	if math.Abs(d) < ms(diffLatencyFloor) || math.Abs(d) < diffLatencyShare*before {
+		return false, false
+	}
+	return d > 0, d < 0
+}
+
+func diffLine(name, before, after, change string, worse, better bool) {
+	switch {
+	case worse:
+		change = color.RedString("%s (worse)", change)
+	case better:
+		change = color.GreenString("%s (better)", change)
+	}
+	logger.Printf(" %-9s %10s -> %-10s %s\n", name, before, after, change)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// runReport prints the report for results stored with --store, limited
+// to the last --since and to the given targets when there are any.
+func runReport(args []string) error {
+	if *storeSpec == "" {
+		return errors.New("report needs --store sqlite:<file>")
+	}
+	path, err := storePath(*storeSpec)
+	if err != nil {
+		return err
+	}
+	var since time.Time
+	if *reportSince > 0 {
+		since = time.Now().Add(-*reportSince)
+	}
+	results, err := loadResults(path, since)
+	if err != nil {
+		return err
+	}
+	runs, err := loadRuns(path, since)
+	if err != nil {
+		return err
+	}
+
+	want := make(map[string]bool)
+	if len(args) > 0 {
+		targets, err := parseTargets(args)
+		if err != nil {
+			return err
+		}
+		for _, t := range targets {
+			want[t.Addr()] = true
+		}
+	}
+
+	// The same address probed over different protocols gives separate
+	// reports.
+	var targets []*Target
+	byKey := make(map[string]*Target)
+	for _, r := range results {
+		if len(want) > 0 && !want[r.Target] {
+			continue
+		}
+		key := r.Proto + " " + r.Target
+		t := byKey[key]
+		if t == nil {
+			t = &Target{Host: r.Host, Port: r.Port, Proto: r.Proto, Stats: &ConnectionStats{}}
+			byKey[key] = t
+			targets = append(targets, t)
+		}
+		replay(t.Stats, r)
+	}
+	if len(targets) == 0 {
+		logger.Println("No stored results match.")
+		return nil
+	}
+
+	for _, t := range targets {
+		// An outage still open at the last stored probe ends there, not now.
+		t.Stats.until = t.Stats.End
+	}
+	printReport(runs, targets)
+	return nil
+}
+
+// replay feeds a stored result into stats as if it had just been probed.
+func replay(stats *ConnectionStats, r Result) {
+	switch {
+	case r.HalfOpen && r.Success:
+		stats.recordSplit(true, fromMs(r.RTT))
+	case r.HalfOpen:
+		stats.recordHalfOpenFailure()
+	case r.Success:
+		stats.recordSuccess(fromMs(r.RTT), r.ISP)
+		if r.Perceived > 0 {
+			stats.recordPerceived(fromMs(r.Perceived))
+		}
+		if r.WarmRTT > 0 {
+			stats.recordWarm(fromMs(r.FirstRTT), fromMs(r.WarmRTT))
+		}
+		if r.TLS > 0 || r.HTTP > 0 {
+			stats.recordPhases(r)
+		}
+		if r.Bytes > 0 {
+			stats.recordTransfer(r)
+		}
+		if r.Edge != "" {
+			stats.recordEdge(r.Edge, fromMs(r.RTT))
+		}
+		if r.Banner != "" {
+			stats.setBanner(r.Banner)
+		}
+	default:
+		stats.recordFailure(r.Category, errors.New(r.Error))
+	}
+	if r.Attempts > 1 {
+		stats.Retries += r.Attempts - 1
+		if r.Success {
+			stats.Recovered++
+		}
+	}
+	if r.Overload && !r.HalfOpen {
+		stats.recordOverload(r)
+	}
+	if *gameMode && !r.HalfOpen {
+		stats.recordGame(r)
+	}
+	stats.observe(r)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dnsTimeout = 5 * time.Second
+
+var resolver = net.DefaultResolver
+
+// setResolver sends all lookups to server instead of the system resolver.
+// With --user-perceived and no server the built-in resolver is used, so
+// each lookup goes to the configured name servers instead of being
+// answered by a local cache such as nscd.
+func setResolver(server string) {
+	if server == "" {
+		if *userPerceived {
+			resolver = &net.Resolver{PreferGo: true}
+		}
+		return
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// resolve returns the addresses host resolves to, the first being the one
+// to probe, and how long resolving took; IP literals are returned as-is
+// with a zero duration.
+func resolve(host string) ([]string, time.Duration, error) {
+	if isValidIP(host) {
+		return []string{host}, 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	took := time.Since(start)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]string, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP.String()
+	}
+	return ips, took, nil
+}
+
+// familyIP picks the first of the addresses host resolved to that network
+// can reach: IPv4 for tcp4 and udp4, IPv6 for tcp6 and udp6, and any for
+// the rest.
+func familyIP(host string, ips []string, network string) (string, error) {
+	var want string
+	switch {
+	case strings.HasSuffix(network, "4"):
+		want = "IPv4"
+	case strings.HasSuffix(network, "6"):
+		want = "IPv6"
+	default:
+		return ips[0], nil
+	}
+	for _, ip := range ips {
+		if v4 := net.ParseIP(ip).To4() != nil; v4 == (want == "IPv4") {
+			return ip, nil
+		}
+	}
+	return "", fmt.Errorf("%s has no %s address", host, want)
+}
+
+// ptrNames remembers the --rdns answer for each address for the rest of
+// the run.
+var ptrNames sync.Map
+
+// reverseName returns the PTR name of ip without its trailing dot, or ""
+// when it has none.
+func reverseName(ip string) string {
+	if name, ok := ptrNames.Load(ip); ok {
+		return name.(string)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	names, err := resolver.LookupAddr(ctx, ip)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		// Try again on the next probe.
+		return ""
+	}
+	name := ""
+	if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	ptrNames.Store(ip, name)
+	return name
+}
+
+// resolverName describes where lookups go, for verbose output.
+func resolverName() string {
+	if *dnsServer == "" {
+		return "system"
+	}
+	return *dnsServer
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"paping/probe"
+)
+
+type Result = probe.Result
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func fromMs(v float64) time.Duration {
+	return time.Duration(v * float64(time.Millisecond))
+}
+
+func init() {
+	probe.RegisterSink("webhook", func(url string) (probe.Sink, error) {
+		return newWebhookSink(url, *webhookBatch, *webhookInterval, *webhookSecret), nil
+	})
+	probe.RegisterSink("ics", func(path string) (probe.Sink, error) {
+		return newICSSink(path), nil
+	})
+	probe.RegisterSink("json", func(path string) (probe.Sink, error) {
+		return newJSONFileSink(path)
+	})
+	probe.RegisterSink("csv", func(path string) (probe.Sink, error) {
+		return newCSVFileSink(path)
+	})
+	probe.RegisterSink("sqlite", func(path string) (probe.Sink, error) {
+		return newSQLiteSink(path)
+	})
+}
+
+// sinkFlags collects repeated --sink name:config flags.
+type sinkFlags []string
+
+func (f *sinkFlags) String() string { return strings.Join(*f, ",") }
+
+func (f *sinkFlags) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func openSinks(specs []string) error {
+	for _, spec := range specs {
+		name, config, _ := strings.Cut(spec, ":")
+		s, err := probe.NewSink(name, config)
+		if err != nil {
+			return fmt.Errorf("--sink %s: %w (available: %s)", spec, err, strings.Join(probe.Sinks(), ", "))
+		}
+		sinks = append(sinks, s)
+	}
+	return nil
+}
+
+var (
+	sinks   []probe.Sink
+	sinksMu sync.Mutex
+)
+
+func emit(r Result) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+
+	for _, s := range sinks {
+		if err := s.Write(r); err != nil {
+			diag.Error("Sink", errKey, err)
+		}
+	}
+}
+
+// flushSinks makes every sink send what it has queued now, as a webhook
+// does for --spike-alert instead of waiting for its batch.
+func flushSinks() {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+
+	for _, s := range sinks {
+		if err := s.Flush(); err != nil {
+			diag.Error("Sink", errKey, err)
+		}
+	}
+}
+
+func closeSinks() {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+
+	for _, s := range sinks {
+		if err := s.Flush(); err != nil {
+			diag.Error("Sink", errKey, err)
+		}
+		if err := s.Close(); err != nil {
+			diag.Error("Sink", errKey, err)
+		}
+	}
+	sinks = nil
+}
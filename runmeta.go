@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// version is set at build time with -ldflags "-X main.version=1.2.3";
+// otherwise the module version or VCS revision is used.
+var version = "dev"
+
+// secretFlags have their values left out of the run metadata.
+var secretFlags = map[string]bool{"lookup-token": true, "webhook-secret": true, "gossip-token": true}
+
+// RunMeta describes a run well enough to reproduce it or to tell why two
+// runs differ: what was run, where, how, and from which network.
+type RunMeta struct {
+	Version    string    `json:"version"`
+	Go         string    `json:"go"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	Host       string    `json:"host"`
+	Start      time.Time `json:"start"`
+	Flags      []string  `json:"flags,omitempty"`
+	Route      string    `json:"route"`
+	Interfaces string    `json:"interfaces"`
+	Resolvers  string    `json:"resolvers"`
+}
+
+// currentRun is the metadata of this run, taken by setup.
+var currentRun RunMeta
+
+func newRunMeta() RunMeta {
+	m := RunMeta{
+		Version:    buildVersion(),
+		Go:         runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Host:       hostname(),
+		Start:      time.Now(),
+		Route:      describeRoute(),
+		Interfaces: describeInterfaces(),
+		Resolvers:  describeResolvers(),
+	}
+	visitCommandLine(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] {
+			value = "redacted"
+		} else if f.Name == "proxy-chain" {
+			value = redactProxies(value)
+		}
+		m.Flags = append(m.Flags, "--"+f.Name+"="+value)
+	})
+	return m
+}
+
+func buildVersion() string {
+	if version != "dev" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	var rev, dirty string
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && len(s.Value) >= 12:
+			rev = s.Value[:12]
+		case s.Key == "vcs.modified" && s.Value == "true":
+			dirty = "-dirty"
+		}
+	}
+	if rev == "" {
+		return version
+	}
+	return version + "+" + rev + dirty
+}
+
+// redactProxies drops the passwords from a --proxy-chain list.
+func redactProxies(list string) string {
+	parts := strings.Split(list, ",")
+	for i, p := range parts {
+		if u, err := url.Parse(p); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), "redacted")
+				parts[i] = u.String()
+			}
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// printRunMeta heads a report with where and how a run was made.
+func printRunMeta(m RunMeta) {
+	logger.Printf("\nRun: paping "+color.CyanString("%s")+" (%s %s/%s) on %s, started %s\n", m.Version, m.Go, m.OS, m.Arch, m.Host, m.Start.Format("2006-01-02 15:04:05 MST"))
+	if len(m.Flags) > 0 {
+		logger.Printf(" Flags: %s\n", strings.Join(m.Flags, " "))
+	}
+	logger.Printf(" Network: route %s; interfaces %s; resolvers %s\n", m.Route, m.Interfaces, m.Resolvers)
+}
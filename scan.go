@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	portOpen     = "open"
+	portClosed   = "closed"
+	portFiltered = "filtered"
+)
+
+// scanCollapse is how many ports in the most common non-open state are
+// listed before they are folded into a single "not shown" line.
+const scanCollapse = 10
+
+type portResult struct {
+	Port  int
+	State string
+	Time  time.Duration
+}
+
+// parsePorts turns a list such as "22,80,8000-8100" into sorted,
+// deduplicated port numbers.
+func parsePorts(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(lo)
+		if err != nil || !isValidPort(from) {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(hi); err != nil || !isValidPort(to) || to < from {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		for p := from; p <= to; p++ {
+			seen[p] = true
+		}
+	}
+	ports := make([]int, 0, len(seen))
+	for p := range seen {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// scanHost connects to each port of host once, at most --concurrency at a
+// time and no faster than --rate, and returns the results by port.
+func scanHost(host string, ports []int) ([]portResult, error) {
+	ips, _, err := resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	ip := ips[0]
+
+	results := make([]portResult, len(ports))
+	sem := make(chan struct{}, *scanConcurrency)
+	b := newTokenBucket(probeRate, *rateBurst)
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		b.wait(context.Background())
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i, port int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = scanPort(ip, port)
+		}(i, port)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func scanPort(ip string, port int) portResult {
+	conn, took, err := dialer.Dial(context.Background(), "tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		if classify(err) == failRefused {
+			return portResult{Port: port, State: portClosed}
+		}
+		return portResult{Port: port, State: portFiltered}
+	}
+	conn.Close()
+	return portResult{Port: port, State: portOpen, Time: took}
+}
+
+// printScan prints the scan table for host, folding the most common
+// closed or filtered state into one line when there are many such ports.
+func printScan(host string, results []portResult) {
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.State]++
+	}
+	hide := portClosed
+	if counts[portFiltered] > counts[portClosed] {
+		hide = portFiltered
+	}
+	if counts[hide] <= scanCollapse {
+		hide = ""
+	}
+
+	logger.Printf("\nScan of "+color.CyanString("%s")+": "+color.GreenString("%d")+" open, %d closed, %d filtered\n", host, counts[portOpen], counts[portClosed], counts[portFiltered])
+	if hide != "" {
+		logger.Printf("Not shown: %d %s ports\n", counts[hide], hide)
+	}
+	if len(results) == counts[hide] {
+		return
+	}
+	logger.Printf("%-7s %-9s %s\n", "PORT", "STATE", "TIME")
+	for _, r := range results {
+		switch r.State {
+		case hide:
+		case portOpen:
+			logger.Printf("%-7d %s %s\n", r.Port, color.GreenString("%-9s", r.State), fmtMs(ms(r.Time)))
+		case portClosed:
+			logger.Printf("%-7d %s -\n", r.Port, color.RedString("%-9s", r.State))
+		default:
+			logger.Printf("%-7d %s -\n", r.Port, color.YellowString("%-9s", r.State))
+		}
+	}
+}
+
+func runScan(hosts []string) error {
+	if len(hosts) == 0 {
+		return errors.New("no hosts given")
+	}
+	if *portsSpec == "" {
+		return errors.New("scan needs -p, e.g. -p 1-1024 or -p 22,80,443")
+	}
+	if *scanConcurrency < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+	ports, err := parsePorts(*portsSpec)
+	if err != nil {
+		return err
+	}
+	for _, host := range hosts {
+		if !isValidHost(host) {
+			return fmt.Errorf("invalid host: %s", host)
+		}
+	}
+
+	for _, host := range hosts {
+		results, err := scanHost(host, ports)
+		if err != nil {
+			logger.Printf(color.RedString("Scan of %s failed: %v\n", host, err))
+			continue
+		}
+		printScan(host, results)
+	}
+	return nil
+}
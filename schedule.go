@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateFlag is a probe rate such as "100/s", "30/m" or a bare per-second
+// number; zero means unlimited.
+type rateFlag float64
+
+func (r *rateFlag) String() string {
+	if *r == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*r), 'f', -1, 64) + "/s"
+}
+
+func (r *rateFlag) Set(s string) error {
+	num, unit, _ := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid rate %q", s)
+	}
+	switch unit {
+	case "", "s":
+	case "m":
+		n /= 60
+	case "h":
+		n /= 3600
+	default:
+		return fmt.Errorf("invalid rate unit %q, want s, m or h", unit)
+	}
+	*r = rateFlag(n)
+	return nil
+}
+
+// tokenBucket limits how often probes start: it holds up to burst tokens,
+// refills at rate per second and each probe takes one, waiting for it if
+// the bucket is empty. A zero rate means no limit.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate rateFlag, burst int) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, waiting for one if the bucket is empty, and reports
+// whether ctx was still live once it had it; a cancelled ctx ends the wait
+// early.
+func (b *tokenBucket) wait(ctx context.Context) bool {
+	if b.rate <= 0 {
+		return ctx.Err() == nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	// Take the token now, even if that leaves the bucket in debt, so that
+	// concurrent callers queue up behind each other.
+	b.tokens--
+	debt := -b.tokens
+	b.mu.Unlock()
+
+	if debt > 0 {
+		timer := time.NewTimer(time.Duration(debt / b.rate * float64(time.Second)))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// jitterRand spreads intervals for --interval-jitter. The global source
+// is not seeded for modules declaring Go 1.19.
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// nextInterval returns the pause before the next probe: --interval, moved
+// by up to --interval-jitter either way at random so probes cannot fall
+// into step with periodic events on the path.
+func nextInterval() time.Duration {
+	if jitter == 0 {
+		return *interval
+	}
+	jitterMu.Lock()
+	f := jitterRand.Float64()*2 - 1
+	jitterMu.Unlock()
+	return time.Duration(float64(*interval) * (1 + f*float64(jitter)/100))
+}
+
+// inflight caps the probes in flight at once across all targets at
+// --max-concurrent, so short intervals or many targets cannot pile up
+// dials during an outage.
+var inflight chan struct{}
+
+func acquireSlot() {
+	if inflight != nil {
+		inflight <- struct{}{}
+	}
+}
+
+func releaseSlot() {
+	if inflight != nil {
+		<-inflight
+	}
+}
+
+// limitReached reports whether sent probes use up --count.
+func limitReached(sent int) bool {
+	return *count > 0 && sent >= *count
+}
+
+// done waits out any maintenance of t, then reports whether t should send
+// no more probes after sent: --count is used up or the target was stopped.
+func (t *Target) done(sent int) bool {
+	t.awaitResume()
+	select {
+	case <-t.ctx.Done():
+		return true
+	default:
+		return limitReached(sent)
+	}
+}
+
+// sleep pauses for d and then out any maintenance begun meanwhile,
+// returning early if t is stopped.
+func (t *Target) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.ctx.Done():
+	case <-timer.C:
+		t.awaitResume()
+	}
+}
+
+// run probes t until --count is used up, or forever without it.
+func run(t *Target) {
+	switch {
+	case *keepaliveMode:
+		runKeepalive(t)
+	case *floodMode:
+		runFlood(t)
+	case *adaptiveMode:
+		runAdaptive(t)
+	default:
+		runInterval(t)
+	}
+}
+
+func runInterval(t *Target) {
+	var wg sync.WaitGroup
+	for sent := 0; !t.done(sent); sent++ {
+		if sent > 0 {
+			t.sleep(nextInterval())
+		}
+		if !t.limit.wait(t.ctx) {
+			break
+		}
+		acquireSlot()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer releaseSlot()
+			ping(t)
+		}()
+	}
+	wg.Wait()
+}
+
+// runAdaptive sends the next probe as soon as the previous one completes.
+func runAdaptive(t *Target) {
+	for sent := 0; !t.done(sent); sent++ {
+		if !t.limit.wait(t.ctx) {
+			break
+		}
+		acquireSlot()
+		ping(t)
+		releaseSlot()
+	}
+}
+
+// runFlood starts probes as fast as --rate and --max-concurrent allow.
+func runFlood(t *Target) {
+	var wg sync.WaitGroup
+	for sent := 0; !t.done(sent); sent++ {
+		if !t.limit.wait(t.ctx) {
+			break
+		}
+		acquireSlot()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer releaseSlot()
+			ping(t)
+		}()
+	}
+	wg.Wait()
+}
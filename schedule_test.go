@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateFlag(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"100/s", 100, true},
+		{"100", 100, true},
+		{"30/m", 0.5, true},
+		{"7200/h", 2, true},
+		{"0", 0, true},
+		{"1/d", 0, false},
+		{"-1/s", 0, false},
+		{"fast", 0, false},
+	}
+	for _, tt := range tests {
+		var r rateFlag
+		err := r.Set(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("Set(%q) error = %v, want ok = %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && float64(r) != tt.want {
+			t.Errorf("Set(%q) = %v, want %v", tt.in, float64(r), tt.want)
+		}
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	b := newTokenBucket(0, 1)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		b.wait(context.Background())
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("1000 waits without a rate took %v", d)
+	}
+}
+
+func TestTokenBucketBurstThenRate(t *testing.T) {
+	b := newTokenBucket(100, 5)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		b.wait(context.Background())
+	}
+	if d := time.Since(start); d > 5*time.Millisecond {
+		t.Errorf("a burst of 5 took %v, want no wait", d)
+	}
+	for i := 0; i < 5; i++ {
+		b.wait(context.Background())
+	}
+	// The next five need a token each at 100/s.
+	if d := time.Since(start); d < 45*time.Millisecond {
+		t.Errorf("10 waits at 100/s with a burst of 5 took %v, want at least 50ms", d)
+	}
+}
+
+func TestTokenBucketConcurrent(t *testing.T) {
+	b := newTokenBucket(200, 1)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 11; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.wait(context.Background())
+		}()
+	}
+	wg.Wait()
+	// Callers queue behind each other: the last of the ten without a
+	// token waits for all ten at 5ms apart.
+	if d := time.Since(start); d < 45*time.Millisecond {
+		t.Errorf("11 concurrent waits at 200/s took %v, want at least 50ms", d)
+	}
+}
+
+func TestTokenBucketCancel(t *testing.T) {
+	b := newTokenBucket(1.0/3600, 1)
+	b.wait(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if b.wait(ctx) {
+		t.Error("wait reported a live context after cancel")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("cancelled wait at 1/h took %v", d)
+	}
+	if newTokenBucket(0, 1).wait(ctx) {
+		t.Error("unlimited wait reported a cancelled context as live")
+	}
+}
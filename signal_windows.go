@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals stop the run and print the report. The runtime delivers
+// both Ctrl-C and Ctrl-Break as os.Interrupt, and closing the console
+// window, logging off or shutting down as SIGTERM; for the latter Windows
+// gives the process a few seconds to finish before killing it.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
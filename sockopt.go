@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sockoptID is the level and option number of a socket option.
+type sockoptID struct {
+	level, opt int
+}
+
+type sockopt struct {
+	name  string
+	id    sockoptID
+	value int
+}
+
+// sockoptFlag holds the socket options given with --sockopt, each as
+// NAME=VALUE with a name from sockoptNames or as LEVEL:OPTION=VALUE in
+// numbers for any other integer option. The flag may be repeated or take
+// several options separated by commas.
+type sockoptFlag []sockopt
+
+func (s *sockoptFlag) String() string {
+	parts := make([]string, len(*s))
+	for i, o := range *s {
+		parts[i] = fmt.Sprintf("%s=%d", o.name, o.value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *sockoptFlag) Set(v string) error {
+	for _, spec := range strings.Split(v, ",") {
+		o, err := parseSockopt(strings.TrimSpace(spec))
+		if err != nil {
+			return err
+		}
+		*s = append(*s, o)
+	}
+	return nil
+}
+
+func parseSockopt(spec string) (sockopt, error) {
+	name, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return sockopt{}, fmt.Errorf("invalid socket option %q, want NAME=VALUE, e.g. TCP_NODELAY=1", spec)
+	}
+	n, err := strconv.ParseInt(value, 0, 32)
+	if err != nil {
+		return sockopt{}, fmt.Errorf("invalid value for socket option %s: %q", name, value)
+	}
+	o := sockopt{name: strings.ToUpper(name), value: int(n)}
+	if level, opt, numeric := strings.Cut(name, ":"); numeric {
+		l, lerr := strconv.ParseInt(level, 0, 32)
+		n, oerr := strconv.ParseInt(opt, 0, 32)
+		if lerr != nil || oerr != nil {
+			return sockopt{}, fmt.Errorf("invalid socket option %q, want LEVEL:OPTION in numbers", name)
+		}
+		o.id = sockoptID{int(l), int(n)}
+		return o, nil
+	}
+	id, ok := sockoptNames[o.name]
+	if !ok {
+		return sockopt{}, fmt.Errorf("unknown socket option %q on this system, give it as LEVEL:OPTION=VALUE", name)
+	}
+	o.id = id
+	return o, nil
+}
+
+// socketControl returns the control function for probe sockets, which
+// applies the marking and then each --sockopt, or nil when there is
+// nothing to set.
+func socketControl() func(network, address string, c syscall.RawConn) error {
+	mark := markingControl()
+	if mark == nil && len(sockopts) == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if mark != nil {
+			if err := mark(network, address, c); err != nil {
+				return err
+			}
+		}
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			for _, o := range sockopts {
+				if err = setSockoptInt(fd, o.id.level, o.id.opt, o.value); err != nil {
+					err = os.NewSyscallError("setsockopt "+o.name, err)
+					return
+				}
+			}
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
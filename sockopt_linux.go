@@ -0,0 +1,31 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// sockoptNames are the socket options --sockopt knows by name.
+var sockoptNames = map[string]sockoptID{
+	"SO_RCVBUF":         {unix.SOL_SOCKET, unix.SO_RCVBUF},
+	"SO_SNDBUF":         {unix.SOL_SOCKET, unix.SO_SNDBUF},
+	"SO_KEEPALIVE":      {unix.SOL_SOCKET, unix.SO_KEEPALIVE},
+	"SO_REUSEADDR":      {unix.SOL_SOCKET, unix.SO_REUSEADDR},
+	"SO_REUSEPORT":      {unix.SOL_SOCKET, unix.SO_REUSEPORT},
+	"SO_MARK":           {unix.SOL_SOCKET, unix.SO_MARK},
+	"SO_PRIORITY":       {unix.SOL_SOCKET, unix.SO_PRIORITY},
+	"TCP_NODELAY":       {unix.IPPROTO_TCP, unix.TCP_NODELAY},
+	"TCP_MAXSEG":        {unix.IPPROTO_TCP, unix.TCP_MAXSEG},
+	"TCP_QUICKACK":      {unix.IPPROTO_TCP, unix.TCP_QUICKACK},
+	"TCP_SYNCNT":        {unix.IPPROTO_TCP, unix.TCP_SYNCNT},
+	"TCP_USER_TIMEOUT":  {unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT},
+	"TCP_KEEPIDLE":      {unix.IPPROTO_TCP, unix.TCP_KEEPIDLE},
+	"TCP_KEEPINTVL":     {unix.IPPROTO_TCP, unix.TCP_KEEPINTVL},
+	"TCP_KEEPCNT":       {unix.IPPROTO_TCP, unix.TCP_KEEPCNT},
+	"TCP_WINDOW_CLAMP":  {unix.IPPROTO_TCP, unix.TCP_WINDOW_CLAMP},
+	"IP_TOS":            {unix.IPPROTO_IP, unix.IP_TOS},
+	"IP_TTL":            {unix.IPPROTO_IP, unix.IP_TTL},
+	"IPV6_TCLASS":       {unix.IPPROTO_IPV6, unix.IPV6_TCLASS},
+	"IPV6_UNICAST_HOPS": {unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS},
+}
+
+func setSockoptInt(fd uintptr, level, opt, value int) error {
+	return unix.SetsockoptInt(int(fd), level, opt, value)
+}
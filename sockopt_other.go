@@ -0,0 +1,23 @@
+//go:build !linux && !windows
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// sockoptNames are the socket options --sockopt knows by name.
+var sockoptNames = map[string]sockoptID{
+	"SO_RCVBUF":         {unix.SOL_SOCKET, unix.SO_RCVBUF},
+	"SO_SNDBUF":         {unix.SOL_SOCKET, unix.SO_SNDBUF},
+	"SO_KEEPALIVE":      {unix.SOL_SOCKET, unix.SO_KEEPALIVE},
+	"SO_REUSEADDR":      {unix.SOL_SOCKET, unix.SO_REUSEADDR},
+	"TCP_NODELAY":       {unix.IPPROTO_TCP, unix.TCP_NODELAY},
+	"TCP_MAXSEG":        {unix.IPPROTO_TCP, unix.TCP_MAXSEG},
+	"IP_TOS":            {unix.IPPROTO_IP, unix.IP_TOS},
+	"IP_TTL":            {unix.IPPROTO_IP, unix.IP_TTL},
+	"IPV6_TCLASS":       {unix.IPPROTO_IPV6, unix.IPV6_TCLASS},
+	"IPV6_UNICAST_HOPS": {unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS},
+}
+
+func setSockoptInt(fd uintptr, level, opt, value int) error {
+	return unix.SetsockoptInt(int(fd), level, opt, value)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// sockoptNames are the socket options --sockopt knows by name.
+var sockoptNames = map[string]sockoptID{
+	"SO_RCVBUF":         {windows.SOL_SOCKET, windows.SO_RCVBUF},
+	"SO_SNDBUF":         {windows.SOL_SOCKET, windows.SO_SNDBUF},
+	"SO_KEEPALIVE":      {windows.SOL_SOCKET, windows.SO_KEEPALIVE},
+	"SO_REUSEADDR":      {windows.SOL_SOCKET, windows.SO_REUSEADDR},
+	"TCP_NODELAY":       {windows.IPPROTO_TCP, windows.TCP_NODELAY},
+	"IP_TOS":            {windows.IPPROTO_IP, windows.IP_TOS},
+	"IP_TTL":            {windows.IPPROTO_IP, windows.IP_TTL},
+	"IPV6_UNICAST_HOPS": {windows.IPPROTO_IPV6, windows.IPV6_UNICAST_HOPS},
+}
+
+func setSockoptInt(fd uintptr, level, opt, value int) error {
+	return windows.SetsockoptInt(windows.Handle(fd), level, opt, value)
+}
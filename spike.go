@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// spikeWarmup is how many successful probes make a baseline before
+	// --spike-threshold starts comparing against it.
+	spikeWarmup = 5
+	// spikeWeight is the weight of each new probe in the baseline, as for
+	// TCP's smoothed RTT (RFC 6298).
+	spikeWeight = 1.0 / 8
+)
+
+// factorFlag is a multiplier given as "3x" or "3".
+type factorFlag float64
+
+func (f *factorFlag) String() string {
+	if *f == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*f), 'f', -1, 64) + "x"
+}
+
+func (f *factorFlag) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(s), "x"), 64)
+	if err != nil || v <= 1 {
+		return fmt.Errorf("invalid factor %q, want more than 1, e.g. 3x", s)
+	}
+	*f = factorFlag(v)
+	return nil
+}
+
+func spikesEnabled() bool {
+	return spikeFactor > 0 || *spikeCeiling > 0
+}
+
+// spikeBaseline is an exponentially weighted moving average of connection
+// times. Spikes are folded in too, so a lasting shift in latency becomes
+// the new normal instead of flagging every probe after it.
+type spikeBaseline struct {
+	avg time.Duration
+	n   int
+}
+
+// observe checks rtt against the --spike-threshold and --spike-ceiling
+// limits, then adds it to the baseline, returning whether it is a spike
+// and the baseline it was judged by.
+func (b *spikeBaseline) observe(rtt time.Duration) (bool, time.Duration) {
+	base := b.avg
+	spike := *spikeCeiling > 0 && rtt > *spikeCeiling
+	if spikeFactor > 0 && b.n >= spikeWarmup && float64(rtt) > float64(spikeFactor)*float64(base) {
+		spike = true
+	}
+
+	if b.n == 0 {
+		b.avg = rtt
+	} else {
+		b.avg += time.Duration(spikeWeight * float64(rtt-b.avg))
+	}
+	b.n++
+	return spike, base
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestFactorFlag(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"3x", 3, true},
+		{"3", 3, true},
+		{"2.5X", 2.5, true},
+		{"1.01x", 1.01, true},
+		{"1x", 0, false},
+		{"0.5", 0, false},
+		{"-3x", 0, false},
+		{"x", 0, false},
+		{"triple", 0, false},
+	}
+	for _, tt := range tests {
+		var f factorFlag
+		err := f.Set(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("Set(%q) error = %v, want ok = %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && float64(f) != tt.want {
+			t.Errorf("Set(%q) = %v, want %v", tt.in, float64(f), tt.want)
+		}
+	}
+}
+
+func TestFactorFlagString(t *testing.T) {
+	var f factorFlag
+	if s := f.String(); s != "" {
+		t.Errorf("unset String() = %q, want empty", s)
+	}
+	f = 2.5
+	if s := f.String(); s != "2.5x" {
+		t.Errorf("String() = %q, want 2.5x", s)
+	}
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"paping/probe"
+)
+
+const historySize = 60
+
+type ConnectionStats struct {
+	sync.Mutex
+	Attempted int
+	Connected int
+	Failed    int
+	MinTime   time.Duration
+	MaxTime   time.Duration
+	TotalTime time.Duration
+	// Jitter is the mean difference between consecutive connection
+	// times, smoothed over 16 probes as in RFC 3550.
+	Jitter    time.Duration
+	lastTime  time.Duration
+	ISP       string
+	LastError string
+	// Failures counts failed probes by category (see classify).
+	Failures map[string]int
+	// History holds the most recent probe times, oldest first; failed
+	// probes are stored as -1.
+	History []time.Duration
+	Samples quantileEstimator
+
+	Banner string
+
+	// PerceivedSamples and PerceivedTotal cover the DNS plus connect times
+	// of --user-perceived probes.
+	PerceivedSamples quantileEstimator
+	PerceivedTotal   time.Duration
+
+	// DistanceKm is how far the target is from here, set for
+	// --speed-of-light.
+	DistanceKm float64
+
+	// HalfOpen and Full cover the alternating probes of --half-open:
+	// SYN to SYN-ACK, and a complete connect. The SYN probes stay out of
+	// the counters and times above.
+	HalfOpenCount  int
+	HalfOpenTotal  time.Duration
+	HalfOpenFailed int
+	FullCount      int
+	FullTotal      time.Duration
+
+	WarmCount  int
+	FirstTotal time.Duration
+	WarmTotal  time.Duration
+
+	// Phases sums each phase timed by --breakdown, by name.
+	Phases map[string]*phaseStats
+
+	// Transfers, TransferBytes and TransferTime sum what --probe-size
+	// moved; MinBitrate and MaxBitrate bound the throughput of a probe.
+	Transfers     int
+	TransferBytes int64
+	TransferTime  time.Duration
+	MinBitrate    float64
+	MaxBitrate    float64
+
+	// Outages lists completed outages; outage tracks the one in progress.
+	// Start and End bound the probes seen, for the downtime percentage.
+	Outages []Outage
+	outage  outageTracker
+	Start   time.Time
+	End     time.Time
+	// until, when set, is where the report ends instead of now, as for
+	// results read back from a store.
+	until time.Time
+
+	// Retries counts extra attempts made because of --retries; Recovered
+	// counts probes that failed at first but succeeded on a retry.
+	Retries   int
+	Recovered int
+
+	// Spikes counts probes flagged by --spike-threshold or
+	// --spike-ceiling against baseline.
+	Spikes   int
+	baseline spikeBaseline
+
+	// Drops counts persistent --keepalive connections found closed.
+	Drops int
+
+	// Overloaded counts probes taken while this machine was overloaded,
+	// by --sample-load; OverloadedConnected and OverloadedTotal cover
+	// those that connected.
+	Overloaded          int
+	OverloadedConnected int
+	OverloadedTotal     time.Duration
+
+	// game splits the run into periods for --game.
+	game gameTracker
+
+	Edge         string
+	EdgeSwitches int
+	Edges        map[string]*EdgeStats
+
+	// ISPChanges lists the ISP transitions seen with --resolve-each;
+	// lastIP is the address the current ISP was seen on.
+	ISPChanges []ISPChange
+	lastIP     string
+}
+
+type EdgeStats struct {
+	Count     int
+	TotalTime time.Duration
+}
+
+func (s *ConnectionStats) recordSuccess(duration time.Duration, isp string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Attempted++
+	s.Connected++
+	s.TotalTime += duration
+	s.ISP = isp
+
+	if s.Samples == nil {
+		s.Samples = newEstimator()
+	}
+	s.Samples.Add(duration)
+
+	if s.MinTime == 0 || duration < s.MinTime {
+		s.MinTime = duration
+	}
+	if duration > s.MaxTime {
+		s.MaxTime = duration
+	}
+	if s.Connected > 1 {
+		diff := duration - s.lastTime
+		if diff < 0 {
+			diff = -diff
+		}
+		s.Jitter += (diff - s.Jitter) / 16
+	}
+	s.lastTime = duration
+	s.pushHistory(duration)
+}
+
+func (s *ConnectionStats) recordSplit(halfOpen bool, d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	if halfOpen {
+		s.HalfOpenCount++
+		s.HalfOpenTotal += d
+	} else {
+		s.FullCount++
+		s.FullTotal += d
+	}
+}
+
+func (s *ConnectionStats) recordHalfOpenFailure() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.HalfOpenFailed++
+}
+
+func (s *ConnectionStats) recordSpike(rtt time.Duration) (bool, time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	spike, base := s.baseline.observe(rtt)
+	if spike {
+		s.Spikes++
+	}
+	return spike, base
+}
+
+func (s *ConnectionStats) recordPerceived(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.PerceivedSamples == nil {
+		s.PerceivedSamples = newEstimator()
+	}
+	s.PerceivedSamples.Add(d)
+	s.PerceivedTotal += d
+}
+
+func (s *ConnectionStats) recordFailure(category string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Attempted++
+	s.Failed++
+	if s.Failures == nil {
+		s.Failures = make(map[string]int)
+	}
+	s.Failures[category]++
+	s.LastError = err.Error()
+	s.pushHistory(-1)
+}
+
+func (s *ConnectionStats) hasBanner() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.Banner != ""
+}
+
+// setBanner stores the service banner unless one was already captured,
+// reporting whether it did.
+func (s *ConnectionStats) setBanner(banner string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Banner != "" {
+		return false
+	}
+	s.Banner = banner
+	return true
+}
+
+func (s *ConnectionStats) recordWarm(first, warm time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.WarmCount++
+	s.FirstTotal += first
+	s.WarmTotal += warm
+}
+
+// observe tracks up/down transitions and returns whether r started an
+// outage (down) or ended one (up).
+func (s *ConnectionStats) observe(r Result) (down, up *Outage) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Start.IsZero() {
+		s.Start = r.Time
+	}
+	s.End = r.Time
+
+	wasDown := s.outage.current != nil
+	if ended := s.outage.observe(r); ended != nil {
+		s.Outages = append(s.Outages, *ended)
+		return nil, ended
+	}
+	if !wasDown && s.outage.current != nil {
+		return s.outage.current, nil
+	}
+	return nil, nil
+}
+
+// setDiagnostics attaches a --diagnose snapshot to the outage that began
+// at start, whether it is still going on or over already.
+func (s *ConnectionStats) setDiagnostics(start time.Time, lines []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if cur := s.outage.current; cur != nil && cur.Start.Equal(start) {
+		cur.Diagnostics = lines
+		return
+	}
+	for i := range s.Outages {
+		if s.Outages[i].Start.Equal(start) {
+			s.Outages[i].Diagnostics = lines
+		}
+	}
+}
+
+// outageSummary returns every outage, including one still in progress as
+// of now, together with the total downtime and its share of the run.
+func (s *ConnectionStats) outageSummary(now time.Time) ([]Outage, time.Duration, float64) {
+	outages := append([]Outage(nil), s.Outages...)
+	if cur := s.outage.current; cur != nil {
+		o := *cur
+		o.End = now
+		outages = append(outages, o)
+	}
+
+	var down time.Duration
+	for _, o := range outages {
+		down += o.Duration()
+	}
+	span := now.Sub(s.Start)
+	if span <= 0 {
+		return outages, down, 0
+	}
+	return outages, down, float64(down) / float64(span) * 100
+}
+
+// reset clears everything recorded so far, as if probing had just begun.
+// The distance to the target is kept, since it does not change.
+func (s *ConnectionStats) reset() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Attempted, s.Connected, s.Failed = 0, 0, 0
+	s.MinTime, s.MaxTime, s.TotalTime = 0, 0, 0
+	s.Jitter, s.lastTime = 0, 0
+	s.ISP, s.LastError, s.Banner = "", "", ""
+	s.Failures = nil
+	s.History = nil
+	s.Samples, s.PerceivedSamples = nil, nil
+	s.PerceivedTotal = 0
+	s.HalfOpenCount, s.HalfOpenTotal, s.HalfOpenFailed, s.FullCount, s.FullTotal = 0, 0, 0, 0, 0
+	s.WarmCount, s.FirstTotal, s.WarmTotal = 0, 0, 0
+	s.Phases = nil
+	s.Transfers, s.TransferBytes, s.TransferTime, s.MinBitrate, s.MaxBitrate = 0, 0, 0, 0, 0
+	s.Outages, s.outage = nil, outageTracker{}
+	s.Start, s.End = time.Time{}, time.Time{}
+	s.Retries, s.Recovered, s.Drops = 0, 0, 0
+	s.Overloaded, s.OverloadedConnected, s.OverloadedTotal = 0, 0, 0
+	s.Spikes, s.baseline = 0, spikeBaseline{}
+	s.game = gameTracker{}
+	s.Edge, s.EdgeSwitches, s.Edges = "", 0, nil
+	s.ISPChanges, s.lastIP = nil, ""
+}
+
+func (s *ConnectionStats) recordGame(r Result) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.game.observe(r)
+}
+
+func (s *ConnectionStats) recordRetry() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Retries++
+}
+
+func (s *ConnectionStats) recordPhases(r Result) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Phases == nil {
+		s.Phases = make(map[string]*phaseStats)
+	}
+	for name, v := range phaseTimes(r) {
+		p := s.Phases[name]
+		if p == nil {
+			p = &phaseStats{}
+			s.Phases[name] = p
+		}
+		p.add(fromMs(v))
+	}
+}
+
+func (s *ConnectionStats) recordTransfer(r Result) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Transfers == 0 || r.Bitrate < s.MinBitrate {
+		s.MinBitrate = r.Bitrate
+	}
+	if r.Bitrate > s.MaxBitrate {
+		s.MaxBitrate = r.Bitrate
+	}
+	s.Transfers++
+	s.TransferBytes += r.Bytes
+	s.TransferTime += time.Duration(float64(r.Bytes) * 8 / r.Bitrate * float64(time.Second))
+}
+
+func (s *ConnectionStats) recordOverload(r Result) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Overloaded++
+	if r.Success {
+		s.OverloadedConnected++
+		s.OverloadedTotal += fromMs(r.RTT)
+	}
+}
+
+func (s *ConnectionStats) recordRecovered() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Recovered++
+}
+
+func (s *ConnectionStats) recordDrop() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Drops++
+}
+
+// recordEdge notes which edge answered a successful probe and reports
+// whether it differs from the one that answered the previous probe.
+func (s *ConnectionStats) recordEdge(edge string, duration time.Duration) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Edges == nil {
+		s.Edges = make(map[string]*EdgeStats)
+	}
+	es := s.Edges[edge]
+	if es == nil {
+		es = &EdgeStats{}
+		s.Edges[edge] = es
+	}
+	es.Count++
+	es.TotalTime += duration
+
+	prev := s.Edge
+	s.Edge = edge
+	if prev != "" && prev != edge {
+		s.EdgeSwitches++
+		return prev, true
+	}
+	return prev, false
+}
+
+// recordISP compares the ISP of r's address with the one seen on the
+// previous successful probe, recording and returning the change if they
+// differ. It must be called before recordSuccess stores r's ISP.
+func (s *ConnectionStats) recordISP(r Result) (ISPChange, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	c := ISPChange{Time: r.Time, From: s.ISP, To: r.ISP, FromIP: s.lastIP, ToIP: r.IP}
+	s.lastIP = r.IP
+	if c.From == "" || c.From == c.To {
+		return c, false
+	}
+	s.ISPChanges = append(s.ISPChanges, c)
+	return c, true
+}
+
+func (s *ConnectionStats) pushHistory(d time.Duration) {
+	if len(s.History) == historySize {
+		copy(s.History, s.History[1:])
+		s.History = s.History[:historySize-1]
+	}
+	s.History = append(s.History, d)
+}
+
+// Snapshot returns an immutable copy of the counters, for computing
+// interval stats with Delta.
+func (s *ConnectionStats) Snapshot() probe.Snapshot {
+	s.Lock()
+	defer s.Unlock()
+
+	snap := probe.Snapshot{
+		Time:      time.Now(),
+		Attempted: s.Attempted,
+		Connected: s.Connected,
+		Failed:    s.Failed,
+		TotalTime: s.TotalTime,
+		MinTime:   s.MinTime,
+		MaxTime:   s.MaxTime,
+	}
+	if s.Failures != nil {
+		snap.Failures = make(map[string]int, len(s.Failures))
+		for category, n := range s.Failures {
+			snap.Failures[category] = n
+		}
+	}
+	return snap
+}
+
+// firstSuccess reports whether the only probe recorded so far succeeded.
+func (s *ConnectionStats) firstSuccess() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.Attempted == 1 && s.Connected == 1
+}
+
+func (s *ConnectionStats) lossPercent() float64 {
+	if s.Attempted == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Attempted) * 100
+}
+
+func (s *ConnectionStats) averageTime() float64 {
+	if s.Connected == 0 {
+		return 0
+	}
+	return ms(s.TotalTime) / float64(s.Connected)
+}
+
+func quantileMs(e quantileEstimator, q float64) float64 {
+	return float64(e.Quantile(q).Microseconds()) / 1000
+}
+
+// printReport prints the metadata of the runs the results come from, then
+// the statistics of every target.
+func printReport(runs []RunMeta, targets []*Target) {
+	probeLines.flush()
+	for _, m := range runs {
+		printRunMeta(m)
+	}
+	for _, t := range targets {
+		printTargetReport(t)
+	}
+}
+
+func printTargetReport(t *Target) {
+	stats := t.Stats
+	stats.Lock()
+	defer stats.Unlock()
+
+	connected, times := "Connected", "Approximate connection times:"
+	if *expectClosed {
+		connected, times = "Closed", "Approximate times to refusal or timeout:"
+	}
+	var successRate float64
+	if stats.Attempted > 0 {
+		successRate = float64(stats.Connected) / float64(stats.Attempted) * 100
+	}
+	logger.Printf("\nConnection statistics for "+color.CyanString("%s")+":\n", t.Addr())
+	logger.Printf("Attempted = "+color.CyanString("%s")+", "+connected+" = "+color.CyanString("%s")+", Failed = "+color.CyanString("%s")+" ("+color.CyanString("%.2f%%")+")\n", fmtCount(stats.Attempted), fmtCount(stats.Connected), fmtCount(stats.Failed), successRate)
+	if stats.Failed > 0 {
+		var parts []string
+		for _, c := range failCategories {
+			if n := stats.Failures[c]; n > 0 {
+				parts = append(parts, c+" = "+color.CyanString("%s", fmtCount(n)))
+			}
+		}
+		logger.Printf("Failures: %s\n", strings.Join(parts, ", "))
+	}
+	if stats.Retries > 0 {
+		logger.Printf("Raw attempts = "+color.CyanString("%s")+", Retries = "+color.CyanString("%s")+", Recovered by retry = "+color.CyanString("%s")+"\n", fmtCount(stats.Attempted+stats.Retries), fmtCount(stats.Retries), fmtCount(stats.Recovered))
+	}
+	logger.Printf("%s\n", times)
+
+	if stats.Connected > 0 {
+		logger.Printf(" Minimum = "+color.CyanString("%s")+", Maximum = "+color.CyanString("%s")+", Average = "+color.CyanString("%s")+"\n", fmtMs(ms(stats.MinTime)), fmtMs(ms(stats.MaxTime)), fmtMs(stats.averageTime()))
+		logger.Printf(" p50 = "+color.CyanString("%s")+", p90 = "+color.CyanString("%s")+", p95 = "+color.CyanString("%s")+", p99 = "+color.CyanString("%s")+"\n", fmtMs(quantileMs(stats.Samples, 0.50)), fmtMs(quantileMs(stats.Samples, 0.90)), fmtMs(quantileMs(stats.Samples, 0.95)), fmtMs(quantileMs(stats.Samples, 0.99)))
+	}
+
+	if stats.Phases != nil {
+		printPhases(stats.Phases)
+	}
+	if stats.Transfers > 0 {
+		printThroughput(stats)
+	}
+
+	if spikesEnabled() && stats.Connected > 0 {
+		var limits []string
+		if spikeFactor > 0 {
+			limits = append(limits, spikeFactor.String()+" baseline")
+		}
+		if *spikeCeiling > 0 {
+			limits = append(limits, spikeCeiling.String())
+		}
+		logger.Printf("Latency spikes = "+color.CyanString("%s")+" (over %s), Baseline = "+color.CyanString("%s")+"\n", fmtCount(stats.Spikes), strings.Join(limits, " or "), fmtMs(ms(stats.baseline.avg)))
+	}
+
+	if *sampleLoad || stats.Overloaded > 0 {
+		printLoad(stats)
+	}
+
+	if stats.DistanceKm > 0 && stats.Connected > 0 {
+		printSpeedOfLight(stats.DistanceKm, stats.averageTime())
+	}
+
+	if *voipMode && stats.Connected > 0 {
+		printVoIP(stats.TotalTime/time.Duration(stats.Connected), stats.Jitter, stats.lossPercent())
+	}
+
+	if bandwidth > 0 && stats.Connected > 0 {
+		printBDP(stats.TotalTime/time.Duration(stats.Connected), fromMs(quantileMs(stats.Samples, 0.95)))
+	}
+
+	if *gameMode {
+		printGame(&stats.game)
+	}
+
+	if stats.PerceivedSamples != nil {
+		avg := ms(stats.PerceivedTotal / time.Duration(stats.Connected))
+		logger.Printf("User-perceived times (cold DNS + connect):\n")
+		logger.Printf(" Average = "+color.CyanString("%s")+", p50 = "+color.CyanString("%s")+", p95 = "+color.CyanString("%s")+", p99 = "+color.CyanString("%s")+"\n", fmtMs(avg), fmtMs(quantileMs(stats.PerceivedSamples, 0.50)), fmtMs(quantileMs(stats.PerceivedSamples, 0.95)), fmtMs(quantileMs(stats.PerceivedSamples, 0.99)))
+	}
+
+	if stats.HalfOpenCount > 0 && stats.FullCount > 0 {
+		half := ms(stats.HalfOpenTotal / time.Duration(stats.HalfOpenCount))
+		full := ms(stats.FullTotal / time.Duration(stats.FullCount))
+		logger.Printf("Half-open vs full connect (experimental):\n")
+		logger.Printf(" SYN/SYN-ACK = "+color.CyanString("%s")+", Full connect = "+color.CyanString("%s")+", Accept delay = "+color.CyanString("%s")+"\n", fmtMs(half), fmtMs(full), fmtMs(full-half))
+		logger.Printf(" SYN probes = "+color.CyanString("%s")+", unanswered = "+color.CyanString("%s")+"\n", fmtCount(stats.HalfOpenCount+stats.HalfOpenFailed), fmtCount(stats.HalfOpenFailed))
+	}
+
+	now := time.Now()
+	if !stats.until.IsZero() {
+		now = stats.until
+	}
+	if outages, down, pct := stats.outageSummary(now); len(outages) > 0 {
+		logger.Printf("Outages = "+color.CyanString("%s")+", Downtime = "+color.CyanString("%s")+" ("+color.CyanString("%.2f%%")+")\n", fmtCount(len(outages)), down.Round(time.Millisecond), pct)
+		for _, o := range outages {
+			logger.Printf(" %s - %s  "+color.RedString("%s")+" (%s failed probes)\n", o.Start.Format("2006-01-02 15:04:05"), o.End.Format("15:04:05"), o.Duration().Round(time.Millisecond), fmtCount(o.Failed))
+			for _, l := range o.Diagnostics {
+				logger.Printf("   %s\n", l)
+			}
+		}
+	}
+
+	if stats.WarmCount > 0 {
+		first := ms(stats.FirstTotal / time.Duration(stats.WarmCount))
+		warm := ms(stats.WarmTotal / time.Duration(stats.WarmCount))
+		logger.Printf("Same-connection round trips:\n")
+		logger.Printf(" Connect = "+color.CyanString("%s")+", First = "+color.CyanString("%s")+", Warm = "+color.CyanString("%s")+", Handshake overhead = "+color.CyanString("%s")+"\n", fmtMs(stats.averageTime()), fmtMs(first), fmtMs(warm), fmtMs(stats.averageTime()-warm))
+	}
+
+	if stats.Banner != "" {
+		logger.Printf("Service banner: %s\n", stats.Banner)
+	}
+
+	if stats.Drops > 0 {
+		logger.Printf("Keepalive connection drops = "+color.CyanString("%s")+"\n", fmtCount(stats.Drops))
+	}
+
+	if len(stats.ISPChanges) > 0 {
+		logger.Printf("ISP changes = "+color.CyanString("%s")+":\n", fmtCount(len(stats.ISPChanges)))
+		for _, c := range stats.ISPChanges {
+			logger.Printf(" %s  %s (%s) -> %s (%s)\n", c.Time.Format("2006-01-02 15:04:05"), c.From, c.FromIP, color.YellowString(c.To), c.ToIP)
+		}
+	}
+
+	if len(stats.Edges) > 0 {
+		logger.Printf("Answering edges ("+color.CyanString("%s")+" switches):\n", fmtCount(stats.EdgeSwitches))
+		edges := make([]string, 0, len(stats.Edges))
+		for edge := range stats.Edges {
+			edges = append(edges, edge)
+		}
+		sort.Strings(edges)
+		for _, edge := range edges {
+			es := stats.Edges[edge]
+			logger.Printf(" %s: "+color.CyanString("%s")+" probes, Average = "+color.CyanString("%s")+"\n", edge, fmtCount(es.Count), fmtMs(ms(es.TotalTime)/float64(es.Count)))
+		}
+	}
+}
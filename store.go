@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const storeSchema = `CREATE TABLE IF NOT EXISTS results (
+	time   INTEGER NOT NULL,
+	target TEXT NOT NULL,
+	result TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS results_time ON results (time);
+CREATE TABLE IF NOT EXISTS runs (
+	start INTEGER NOT NULL,
+	meta  TEXT NOT NULL
+);`
+
+// sqliteSink persists every probe result so that "paping report" can
+// rebuild summaries from it later. Each row keeps the full result as JSON
+// next to the columns queries filter on, and each run that writes to the
+// store records its metadata in the runs table.
+type sqliteSink struct {
+	db     *sql.DB
+	insert *sql.Stmt
+}
+
+func openStore(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	// WAL lets "paping report" read while a run is still writing.
+	if _, err := db.Exec("PRAGMA journal_mode = WAL; PRAGMA busy_timeout = 5000;" + storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func newSQLiteSink(path string) (*sqliteSink, error) {
+	db, err := openStore(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := json.Marshal(currentRun)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	if _, err := db.Exec("INSERT INTO runs (start, meta) VALUES (?, ?)", currentRun.Start.UnixNano(), string(meta)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	insert, err := db.Prepare("INSERT INTO results (time, target, result) VALUES (?, ?, ?)")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	return &sqliteSink{db: db, insert: insert}, nil
+}
+
+func (s *sqliteSink) Write(r Result) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	if _, err := s.insert.Exec(r.Time.UnixNano(), r.Target, string(data)); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteSink) Flush() error {
+	return nil
+}
+
+func (s *sqliteSink) Close() error {
+	s.insert.Close()
+	return s.db.Close()
+}
+
+// storePath checks a --store backend:path spec and returns the path.
+func storePath(spec string) (string, error) {
+	backend, path, ok := strings.Cut(spec, ":")
+	if !ok || backend != "sqlite" || path == "" {
+		return "", fmt.Errorf("invalid --store %q, want sqlite:<file>", spec)
+	}
+	return path, nil
+}
+
+// loadResults reads the results stored at path since the given time, or
+// all of them when since is zero, oldest first.
+func loadResults(path string, since time.Time) ([]Result, error) {
+	db, err := openStore(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var from int64
+	if !since.IsZero() {
+		from = since.UnixNano()
+	}
+	rows, err := db.Query("SELECT result FROM results WHERE time >= ? ORDER BY time", from)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		var r Result
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// loadRuns reads the metadata of the runs stored at path that were still
+// going at since, or of all of them when since is zero, oldest first.
+func loadRuns(path string, since time.Time) ([]RunMeta, error) {
+	db, err := openStore(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// A run that started before since but went on past it is identified
+	// by the latest start at or before since.
+	var from int64
+	if !since.IsZero() {
+		if err := db.QueryRow("SELECT COALESCE(MAX(start), 0) FROM runs WHERE start <= ?", since.UnixNano()).Scan(&from); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+	}
+	rows, err := db.Query("SELECT meta FROM runs WHERE start >= ? ORDER BY start", from)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []RunMeta
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		var m RunMeta
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		runs = append(runs, m)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"paping/probe"
+)
+
+// startSummaries prints each target's stats for the past interval every
+// --summary-every until the returned function is called.
+func startSummaries(targets []*Target, every time.Duration) (stop func()) {
+	done := make(chan struct{})
+	if every <= 0 {
+		return func() {}
+	}
+
+	prev := make([]probe.Snapshot, len(targets))
+	for i, t := range targets {
+		prev[i] = t.Stats.Snapshot()
+	}
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			probeLines.flush()
+			for i, t := range targets {
+				cur := t.Stats.Snapshot()
+				printSummary(t, prev[i].Time, cur.Delta(prev[i]))
+				prev[i] = cur
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func printSummary(t *Target, from time.Time, d probe.Snapshot) {
+	line := "Summary " + from.Format("15:04:05") + "-" + d.Time.Format("15:04:05") + " " + color.CyanString("%s", t.Addr()) +
+		": attempted=" + color.CyanString("%d", d.Attempted) +
+		" loss=" + color.CyanString("%.2f%%", d.Loss()) +
+		" avg=" + color.CyanString("%s", fmtMs(ms(d.Average())))
+	var failures []string
+	for _, c := range failCategories {
+		if n := d.Failures[c]; n > 0 {
+			failures = append(failures, c+"="+color.RedString("%d", n))
+		}
+	}
+	if len(failures) > 0 {
+		line += " " + strings.Join(failures, " ")
+	}
+	logger.Println(line)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelTCPInfo reads what the kernel measured on conn while connecting:
+// its smoothed round trip and variation, and how many segments it has
+// retransmitted, SYNs included. It only works on direct TCP connections.
+func kernelTCPInfo(conn net.Conn) (srtt, rttvar time.Duration, retrans int, ok bool) {
+	tc, isTCP := conn.(*net.TCPConn)
+	if !isTCP {
+		return 0, 0, 0, false
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	var info *unix.TCPInfo
+	raw.Control(func(fd uintptr) {
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil || info == nil {
+		return 0, 0, 0, false
+	}
+	us := time.Microsecond
+	return time.Duration(info.Rtt) * us, time.Duration(info.Rttvar) * us, int(info.Total_retrans), true
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"net"
+	"time"
+)
+
+func kernelTCPInfo(conn net.Conn) (srtt, rttvar time.Duration, retrans int, ok bool) {
+	return 0, 0, 0, false
+}
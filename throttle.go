@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// lineLimiter caps probe lines at --max-lines-per-sec so a slow terminal
+// does not hold up probing. Lines over the cap are dropped and counted;
+// the count is printed when the next window opens. Sinks still get every
+// result.
+type lineLimiter struct {
+	mu      sync.Mutex
+	start   time.Time
+	printed int
+	dropped int
+}
+
+var probeLines lineLimiter
+
+// allow reports whether a probe line may be printed now.
+func (l *lineLimiter) allow() bool {
+	if *maxLinesPerSec <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := time.Now(); now.Sub(l.start) >= time.Second {
+		l.flushLocked()
+		l.start = now
+		l.printed = 0
+	}
+	if l.printed >= *maxLinesPerSec {
+		l.dropped++
+		return false
+	}
+	l.printed++
+	return true
+}
+
+// flush prints the number of lines dropped since the last report, if any.
+func (l *lineLimiter) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flushLocked()
+}
+
+func (l *lineLimiter) flushLocked() {
+	if l.dropped > 0 {
+		logger.Print(color.YellowString("... %d lines suppressed\n", l.dropped))
+		l.dropped = 0
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// checkProbeSize validates --probe-size, which needs a mode that has
+// something to transfer on.
+func checkProbeSize() error {
+	if *probeSize < 0 {
+		return errors.New("--probe-size must not be negative")
+	}
+	if *probeSize > 0 && !*keepaliveMode && !breakdownHTTP {
+		return errors.New("--probe-size needs --keepalive or --breakdown http")
+	}
+	return nil
+}
+
+// echoTransfer writes size bytes to conn and reads them back from an echo
+// service, returning the time to the first byte back, which stands in for
+// the round trip, and the time until the last.
+func echoTransfer(conn net.Conn, size int) (first, total time.Duration, err error) {
+	conn.SetDeadline(time.Now().Add(keepaliveTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// Write from another goroutine so that a payload larger than the
+	// socket buffers cannot stall both ends.
+	written := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := conn.Write(make([]byte, size))
+		written <- err
+	}()
+	buf := make([]byte, size)
+	n, err := conn.Read(buf)
+	if err == nil {
+		first = time.Since(start)
+		_, err = io.ReadFull(conn, buf[n:])
+	}
+	total = time.Since(start)
+	if werr := <-written; err == nil {
+		err = werr
+	}
+	return first, total, err
+}
+
+// setTransfer records on r that n bytes moved in d.
+func setTransfer(r *Result, n int64, d time.Duration) {
+	if n == 0 || d <= 0 {
+		return
+	}
+	r.Bytes = n
+	r.Bitrate = float64(n) * 8 / d.Seconds()
+}
+
+// fmtBitrate formats bits per second to three significant digits.
+func fmtBitrate(bps float64) string {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(bps, 'g', 3, 64), 64)
+	return fmtBandwidth(rounded)
+}
+
+// printThroughput sums up the --probe-size transfers. The average is the
+// total moved over the total time, so large slow transfers weigh more
+// than in a plain mean of the probes.
+func printThroughput(stats *ConnectionStats) {
+	avg := float64(stats.TransferBytes) * 8 / stats.TransferTime.Seconds()
+	logger.Printf("Throughput (min / avg / max): "+color.CyanString("%s")+" / "+color.CyanString("%s")+" / "+color.CyanString("%s")+", %s in %s transfers\n",
+		fmtBitrate(stats.MinBitrate), fmtBitrate(avg), fmtBitrate(stats.MaxBitrate), fmtBytes(float64(stats.TransferBytes)), fmtCount(stats.Transfers))
+}
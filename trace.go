@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const traceTimeout = 2 * time.Second
+
+var errTraceUnsupported = errors.New("TCP trace is not supported on this platform")
+
+// hopReply is the answer to one TTL-limited SYN: either a router reporting
+// the TTL expired, or the target itself accepting or refusing it.
+type hopReply struct {
+	Addr    string
+	RTT     time.Duration
+	Reached bool
+	Open    bool
+	Timeout bool
+}
+
+// hopStats accumulates replies for one TTL across trace rounds.
+type hopStats struct {
+	TTL       int
+	Addr      string
+	Sent      int
+	Received  int
+	Reached   bool
+	Open      bool
+	Last      time.Duration
+	Best      time.Duration
+	Worst     time.Duration
+	TotalTime time.Duration
+}
+
+func (h *hopStats) add(reply hopReply) {
+	h.Sent++
+	if reply.Timeout {
+		return
+	}
+	h.Received++
+	if reply.Addr != "" {
+		h.Addr = reply.Addr
+	}
+	h.Reached = h.Reached || reply.Reached
+	h.Open = reply.Open
+	h.Last = reply.RTT
+	h.TotalTime += reply.RTT
+	if h.Best == 0 || reply.RTT < h.Best {
+		h.Best = reply.RTT
+	}
+	if reply.RTT > h.Worst {
+		h.Worst = reply.RTT
+	}
+}
+
+func (h *hopStats) loss() float64 {
+	if h.Sent == 0 {
+		return 0
+	}
+	return float64(h.Sent-h.Received) / float64(h.Sent) * 100
+}
+
+func (h *hopStats) average() time.Duration {
+	if h.Received == 0 {
+		return 0
+	}
+	return h.TotalTime / time.Duration(h.Received)
+}
+
+func resolveTrace(t *Target) (net.IP, error) {
+	ips, _, err := resolve(t.Host)
+	if err != nil {
+		return nil, err
+	}
+	return net.ParseIP(ips[0]), nil
+}
+
+// runTrace prints the path to t hop by hop, like tcptraceroute, stopping
+// once the target itself answers or t is stopped.
+func runTrace(t *Target) error {
+	ip, err := resolveTrace(t)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("Tracing "+color.CyanString("%s")+" (%s) over TCP, %d hops max\n", t.Addr(), ip, *maxHops)
+	for ttl := 1; ttl <= *maxHops && t.ctx.Err() == nil; ttl++ {
+		h := &hopStats{TTL: ttl}
+		times := make([]string, 0, *traceQueries)
+		for i := 0; i < *traceQueries && t.ctx.Err() == nil; i++ {
+			reply, err := traceHop(ip, t.Port, ttl, traceTimeout)
+			if err != nil {
+				return err
+			}
+			h.add(reply)
+			if reply.Timeout {
+				times = append(times, "*")
+			} else {
+				times = append(times, fmtMs(ms(reply.RTT)))
+			}
+		}
+
+		addr := h.Addr
+		if addr == "" {
+			addr = "*"
+		}
+		if h.Reached {
+			addr += " " + hopState(h)
+		}
+		logger.Printf("%3d  %s  %s\n", ttl, color.GreenString("%s", addr), strings.Join(times, "  "))
+		if h.Reached {
+			return nil
+		}
+	}
+	return nil
+}
+
+func hopLabel(h *hopStats) string {
+	if h.Open {
+		return "[open]"
+	}
+	return "[closed]"
+}
+
+func hopState(h *hopStats) string {
+	if h.Open {
+		return color.GreenString(hopLabel(h))
+	}
+	return color.RedString(hopLabel(h))
+}
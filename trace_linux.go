@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// traceHop sends a single SYN to ip:port with the given TTL. The socket has
+// IP_RECVERR set so the ICMP time-exceeded reply from the router where the
+// TTL ran out is queued on it, which lets an unprivileged process learn
+// the router's address.
+func traceHop(ip net.IP, port, ttl int, timeout time.Duration) (hopReply, error) {
+	family, level, ttlOpt, errOpt := unix.AF_INET, unix.IPPROTO_IP, unix.IP_TTL, unix.IP_RECVERR
+	var sa unix.Sockaddr
+	if ip4 := ip.To4(); ip4 != nil {
+		addr := &unix.SockaddrInet4{Port: port}
+		copy(addr.Addr[:], ip4)
+		sa = addr
+	} else {
+		family, level, ttlOpt, errOpt = unix.AF_INET6, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, unix.IPV6_RECVERR
+		addr := &unix.SockaddrInet6{Port: port}
+		copy(addr.Addr[:], ip.To16())
+		sa = addr
+	}
+
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return hopReply{}, err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.SetsockoptInt(fd, level, ttlOpt, ttl); err != nil {
+		return hopReply{}, err
+	}
+	if err := unix.SetsockoptInt(fd, level, errOpt, 1); err != nil {
+		return hopReply{}, err
+	}
+
+	start := time.Now()
+	if err := unix.Connect(fd, sa); err != nil && err != unix.EINPROGRESS {
+		return hopReply{}, err
+	}
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+	for {
+		// A negative timeout makes poll wait forever, which a late EINTR
+		// would otherwise ask for; at zero poll only checks and an
+		// unanswered hop times out below.
+		wait := time.Until(start.Add(timeout)).Milliseconds()
+		if wait < 0 {
+			wait = 0
+		}
+		n, err := unix.Poll(fds, int(wait))
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return hopReply{}, err
+		}
+		if n == 0 {
+			return hopReply{Timeout: true}, nil
+		}
+		break
+	}
+	rtt := time.Since(start)
+
+	soErr, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return hopReply{}, err
+	}
+	switch unix.Errno(soErr) {
+	case 0:
+		return hopReply{Addr: ip.String(), RTT: rtt, Reached: true, Open: true}, nil
+	case unix.ECONNREFUSED:
+		return hopReply{Addr: ip.String(), RTT: rtt, Reached: true}, nil
+	}
+
+	offender := readOffender(fd)
+	if offender == "" {
+		return hopReply{Timeout: true}, nil
+	}
+	return hopReply{Addr: offender, RTT: rtt, Reached: offender == ip.String()}, nil
+}
+
+// readOffender returns the address of the host that sent the ICMP error
+// queued on fd, or "" if there is none.
+func readOffender(fd int) string {
+	oob := make([]byte, 512)
+	_, oobn, _, _, err := unix.Recvmsg(fd, make([]byte, 1), oob, unix.MSG_ERRQUEUE)
+	if err != nil {
+		return ""
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return ""
+	}
+
+	const extErrSize = int(unsafe.Sizeof(unix.SockExtendedErr{}))
+	for _, m := range msgs {
+		data := m.Data
+		if len(data) < extErrSize+2 {
+			continue
+		}
+		sa := data[extErrSize:]
+		switch binary.LittleEndian.Uint16(sa) {
+		case unix.AF_INET:
+			if len(sa) >= 8 {
+				return net.IP(sa[4:8]).String()
+			}
+		case unix.AF_INET6:
+			if len(sa) >= 24 {
+				return net.IP(sa[8:24]).String()
+			}
+		}
+	}
+	return ""
+}
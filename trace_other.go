@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"net"
+	"time"
+)
+
+func traceHop(ip net.IP, port, ttl int, timeout time.Duration) (hopReply, error) {
+	return hopReply{}, errTraceUnsupported
+}
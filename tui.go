@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	leaveAltScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen    = "\x1b[H\x1b[2J"
+)
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+type dashboard struct {
+	targets []*Target
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func startDashboard(targets []*Target) *dashboard {
+	d := &dashboard{
+		targets: targets,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	fmt.Fprint(color.Output, enterAltScreen)
+	go d.loop()
+	return d
+}
+
+func (d *dashboard) Close() {
+	close(d.stop)
+	<-d.done
+	fmt.Fprint(color.Output, leaveAltScreen)
+}
+
+func (d *dashboard) loop() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		d.render()
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *dashboard) render() {
+	var b strings.Builder
+	b.WriteString(clearScreen)
+	fmt.Fprintf(&b, "%s  %s\n\n", color.New(color.Bold).Sprint("paping"), time.Now().Format("15:04:05"))
+	for _, t := range d.targets {
+		writePanel(&b, t)
+	}
+	b.WriteString("Press Ctrl+C to stop and print the report.\n")
+	fmt.Fprint(color.Output, b.String())
+}
+
+func writePanel(b *strings.Builder, t *Target) {
+	stats := t.Stats
+	stats.Lock()
+	defer stats.Unlock()
+
+	status := color.YellowString("WAIT")
+	last := "-"
+	if n := len(stats.History); n > 0 {
+		down, up := stateWords()
+		if stats.History[n-1] < 0 {
+			status = color.RedString(down)
+		} else {
+			status = color.GreenString(up)
+			last = fmtMs(ms(stats.History[n-1]))
+		}
+	}
+
+	isp := stats.ISP
+	if isp == "" {
+		isp = "-"
+	}
+	lastErr := stats.LastError
+	if lastErr == "" {
+		lastErr = "-"
+	}
+
+	fmt.Fprintf(b, "%s  ISP: %s\n", color.CyanString("%-24s", t.Addr()), isp)
+	fmt.Fprintf(b, "  Status: %s  Loss: %6.2f%% (%d/%d)  Last: %s  Min/Avg/Max: %s/%s/%s\n",
+		status, stats.lossPercent(), stats.Failed, stats.Attempted, last,
+		fmtMs(ms(stats.MinTime)), fmtMs(stats.averageTime()), fmtMs(ms(stats.MaxTime)))
+	fmt.Fprintf(b, "  %s\n", sparkline(stats.History))
+	fmt.Fprintf(b, "  Last error: %s\n\n", color.RedString(lastErr))
+}
+
+// sparkline draws the probe history scaled between its fastest and slowest
+// successful probe; failures are drawn as a red cross.
+func sparkline(history []time.Duration) string {
+	var lo, hi time.Duration = -1, 0
+	for _, d := range history {
+		if d < 0 {
+			continue
+		}
+		if lo < 0 || d < lo {
+			lo = d
+		}
+		if d > hi {
+			hi = d
+		}
+	}
+
+	var b strings.Builder
+	for _, d := range history {
+		if d < 0 {
+			b.WriteString(color.RedString("×"))
+			continue
+		}
+		idx := 0
+		if hi > lo {
+			idx = int(float64(d-lo) / float64(hi-lo) * float64(len(sparkBars)-1))
+		}
+		b.WriteString(color.GreenString(string(sparkBars[idx])))
+	}
+	return b.String()
+}
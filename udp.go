@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"paping/probe"
+)
+
+const (
+	defaultUDPCount = 100
+	udpMagic        = "PAPU"
+	// udpHeaderSize is the magic, a 4-byte sequence number and the 8-byte
+	// send time in nanoseconds.
+	udpHeaderSize = 16
+)
+
+// udpTest tracks a numbered-datagram run against an echo server.
+type udpTest struct {
+	mu        sync.Mutex
+	sent      map[uint32]time.Time
+	seen      map[uint32]bool
+	maxSeq    int64
+	received  int
+	dups      int
+	reordered int
+	rtts      quantileEstimator
+	min, max  time.Duration
+	total     time.Duration
+	lastRTT   time.Duration
+	jitter    float64
+}
+
+// runUDP sends --count numbered datagrams to t at --interval, reading the
+// echoes back to measure loss, duplicates, reordering and the round-trip
+// distribution. Stopping t ends the run early with a report on the
+// datagrams sent so far.
+func runUDP(t *Target) error {
+	if *wgConfig != "" {
+		return errors.New("the UDP test does not support --wg-config")
+	}
+	chain, err := probe.ParseProxyChain(*proxyFlag)
+	if err != nil {
+		return err
+	}
+	if len(chain) > 1 || len(chain) == 1 && chain[0].Scheme != "socks5" {
+		return errors.New("the UDP test can only go through a single socks5:// proxy")
+	}
+	ips, _, err := resolve(t.Host)
+	if err != nil {
+		return err
+	}
+	local, err := localAddr(*ifaceName)
+	if err != nil {
+		return err
+	}
+	base := probe.ControlDialer(local, socketControl())
+	addr := net.JoinHostPort(ips[0], strconv.Itoa(t.Port))
+	var conn net.Conn
+	if len(chain) == 1 {
+		// Datagrams go through the proxy's UDP relay instead of direct.
+		ctx, cancel := context.WithTimeout(t.ctx, *probeTimeout)
+		conn, err = probe.DialSOCKS5UDP(ctx, chain[0], base, addr)
+		cancel()
+	} else {
+		conn, err = base(t.ctx, "udp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	n := *count
+	if n == 0 {
+		n = defaultUDPCount
+	}
+	u := &udpTest{sent: make(map[uint32]time.Time), seen: make(map[uint32]bool), maxSeq: -1, rtts: newEstimator()}
+	logger.Printf("UDP test to "+color.CyanString("%s")+" (%s): %d datagrams\n", t.Addr(), ips[0], n)
+	printMarking()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		u.receive(conn)
+	}()
+
+	buf := make([]byte, udpHeaderSize)
+	copy(buf, udpMagic)
+	sent := 0
+	for seq := 0; seq < n; seq++ {
+		if seq > 0 {
+			t.sleep(nextInterval())
+		}
+		if t.ctx.Err() != nil {
+			break
+		}
+		sent++
+		now := time.Now()
+		binary.BigEndian.PutUint32(buf[4:], uint32(seq))
+		binary.BigEndian.PutUint64(buf[8:], uint64(now.UnixNano()))
+		u.mu.Lock()
+		u.sent[uint32(seq)] = now
+		u.mu.Unlock()
+		if _, err := conn.Write(buf); err != nil {
+			logger.Printf(color.RedString("seq=%d send failed: %v\n", seq, err))
+		}
+	}
+
+	// Give the last datagrams one timeout to come back.
+	conn.SetReadDeadline(time.Now().Add(*probeTimeout))
+	<-done
+	u.print(t, sent)
+	return nil
+}
+
+func (u *udpTest) receive(conn net.Conn) {
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		now := time.Now()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return
+			}
+			// An ICMP port unreachable from the last datagram surfaces
+			// here as a read error; keep listening for the rest.
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if n < udpHeaderSize || string(buf[:4]) != udpMagic {
+			continue
+		}
+		u.record(binary.BigEndian.Uint32(buf[4:]), now)
+	}
+}
+
+func (u *udpTest) record(seq uint32, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	sentAt, ok := u.sent[seq]
+	if !ok {
+		return
+	}
+	rtt := now.Sub(sentAt)
+	note := ""
+	switch {
+	case u.seen[seq]:
+		u.dups++
+		logger.Printf("seq=%d time=%s %s\n", seq, fmtMs(ms(rtt)), color.YellowString("(DUP!)"))
+		return
+	case int64(seq) < u.maxSeq:
+		u.reordered++
+		note = " " + color.YellowString("(reordered)")
+	default:
+		u.maxSeq = int64(seq)
+	}
+	u.seen[seq] = true
+	u.received++
+	u.rtts.Add(rtt)
+	u.total += rtt
+	if u.min == 0 || rtt < u.min {
+		u.min = rtt
+	}
+	if rtt > u.max {
+		u.max = rtt
+	}
+	// Jitter is smoothed over 16 packets as in RFC 3550, but computed from
+	// round trips since the two clocks are not synchronized.
+	if u.received > 1 {
+		u.jitter += (math.Abs(float64(rtt-u.lastRTT)) - u.jitter) / 16
+	}
+	u.lastRTT = rtt
+	if !*quiet {
+		logger.Printf("seq=%d time=%s%s\n", seq, color.GreenString("%s", fmtMs(ms(rtt))), note)
+	}
+}
+
+func (u *udpTest) print(t *Target, sent int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	lost := sent - u.received
+	logger.Printf("\nUDP statistics for "+color.CyanString("%s")+":\n", t.Addr())
+	logger.Printf("Sent = "+color.CyanString("%s")+", Received = "+color.CyanString("%s")+", Lost = "+color.CyanString("%s")+" ("+color.CyanString("%.2f%%")+"), Duplicates = "+color.CyanString("%s")+", Reordered = "+color.CyanString("%s")+"\n",
+		fmtCount(sent), fmtCount(u.received), fmtCount(lost), float64(lost)/float64(sent)*100, fmtCount(u.dups), fmtCount(u.reordered))
+	if u.received == 0 {
+		return
+	}
+	logger.Printf("Round trip times:\n")
+	logger.Printf(" Minimum = "+color.CyanString("%s")+", Maximum = "+color.CyanString("%s")+", Average = "+color.CyanString("%s")+", Jitter = "+color.CyanString("%s")+"\n",
+		fmtMs(ms(u.min)), fmtMs(ms(u.max)), fmtMs(ms(u.total/time.Duration(u.received))), fmtMs(ms(time.Duration(u.jitter))))
+	logger.Printf(" p50 = "+color.CyanString("%s")+", p90 = "+color.CyanString("%s")+", p95 = "+color.CyanString("%s")+", p99 = "+color.CyanString("%s")+"\n",
+		fmtMs(quantileMs(u.rtts, 0.50)), fmtMs(quantileMs(u.rtts, 0.90)), fmtMs(quantileMs(u.rtts, 0.95)), fmtMs(quantileMs(u.rtts, 0.99)))
+	if *voipMode {
+		printVoIP(u.total/time.Duration(u.received), time.Duration(u.jitter), float64(lost)/float64(sent)*100)
+	}
+	if bandwidth > 0 {
+		printBDP(u.total/time.Duration(u.received), fromMs(quantileMs(u.rtts, 0.95)))
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	unitsIEC = "iec"
+	unitsSI  = "si"
+)
+
+func checkUnits() error {
+	if *unitsFormat != unitsIEC && *unitsFormat != unitsSI {
+		return fmt.Errorf("invalid --units-format %q, want iec or si", *unitsFormat)
+	}
+	if *secondsAbove < 0 {
+		return fmt.Errorf("--seconds-above must not be negative")
+	}
+	return nil
+}
+
+// groupDigits puts --thousands-sep between each group of three digits in
+// the whole part of the formatted number s.
+func groupDigits(s string) string {
+	if *thousandsSep == "" {
+		return s
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		sign, s = s[:1], s[1:]
+	}
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i:]
+	}
+	var b strings.Builder
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(*thousandsSep)
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String() + frac
+}
+
+// fmtCount formats a number of probes, outages and the like.
+func fmtCount(n int) string {
+	return groupDigits(strconv.Itoa(n))
+}
+
+// fmtMs formats a latency given in milliseconds, in seconds from
+// --seconds-above up.
+func fmtMs(v float64) string {
+	if *secondsAbove > 0 && v >= ms(*secondsAbove) {
+		return groupDigits(fmt.Sprintf("%.3f", v/1000)) + "s"
+	}
+	return groupDigits(fmt.Sprintf("%.2f", v)) + "ms"
+}
+
+// fmtMsDelta formats a change in latency like fmtMs, always with a sign.
+func fmtMsDelta(v float64) string {
+	if v < 0 {
+		return "-" + fmtMs(-v)
+	}
+	return "+" + fmtMs(v)
+}
+
+// fmtBytes formats a byte count in the --units-format units: powers of
+// 1024 (KiB, MiB) or of 1000 (kB, MB).
+func fmtBytes(n float64) string {
+	base, names := 1024.0, []string{"KiB", "MiB", "GiB"}
+	if *unitsFormat == unitsSI {
+		base, names = 1000, []string{"kB", "MB", "GB"}
+	}
+	if n < base {
+		return groupDigits(fmt.Sprintf("%.0f", n)) + " bytes"
+	}
+	unit := 0
+	for n /= base; n >= base && unit < len(names)-1; unit++ {
+		n /= base
+	}
+	return groupDigits(fmt.Sprintf("%.2f", n)) + " " + names[unit]
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// setFlag sets a flag variable for the length of a test.
+func setFlag[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func TestGroupDigits(t *testing.T) {
+	setFlag(t, thousandsSep, ",")
+	tests := map[string]string{
+		"1":            "1",
+		"999":          "999",
+		"1000":         "1,000",
+		"1234567":      "1,234,567",
+		"-1234567":     "-1,234,567",
+		"+1000":        "+1,000",
+		"1234567.8912": "1,234,567.8912",
+		"12.50":        "12.50",
+	}
+	for in, want := range tests {
+		if got := groupDigits(in); got != want {
+			t.Errorf("groupDigits(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGroupDigitsOff(t *testing.T) {
+	setFlag(t, thousandsSep, "")
+	if got := groupDigits("1234567"); got != "1234567" {
+		t.Errorf("groupDigits without --thousands-sep = %q", got)
+	}
+}
+
+func TestFmtMs(t *testing.T) {
+	setFlag(t, thousandsSep, " ")
+	setFlag(t, secondsAbove, time.Second)
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0.5, "0.50ms"},
+		{12.345, "12.35ms"},
+		{999.99, "999.99ms"},
+		{1000, "1.000s"},
+		{1234567, "1 234.567s"},
+	}
+	for _, tt := range tests {
+		if got := fmtMs(tt.in); got != tt.want {
+			t.Errorf("fmtMs(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFmtMsDelta(t *testing.T) {
+	setFlag(t, thousandsSep, ",")
+	setFlag(t, secondsAbove, time.Second)
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "+0.00ms"},
+		{12.5, "+12.50ms"},
+		{-12.5, "-12.50ms"},
+		{-1500, "-1.500s"},
+		{1234567, "+1,234.567s"},
+	}
+	for _, tt := range tests {
+		if got := fmtMsDelta(tt.in); got != tt.want {
+			t.Errorf("fmtMsDelta(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFmtBytes(t *testing.T) {
+	tests := []struct {
+		units string
+		in    float64
+		want  string
+	}{
+		{unitsIEC, 512, "512 bytes"},
+		{unitsIEC, 1536, "1.50 KiB"},
+		{unitsIEC, 3 << 20, "3.00 MiB"},
+		{unitsIEC, 5 << 40, "5120.00 GiB"},
+		{unitsSI, 1500, "1.50 kB"},
+		{unitsSI, 2e9, "2.00 GB"},
+	}
+	for _, tt := range tests {
+		setFlag(t, unitsFormat, tt.units)
+		if got := fmtBytes(tt.in); got != tt.want {
+			t.Errorf("fmtBytes(%v) in %s = %q, want %q", tt.in, tt.units, got, tt.want)
+		}
+	}
+}
+
+func TestCheckUnits(t *testing.T) {
+	setFlag(t, unitsFormat, "metric")
+	if checkUnits() == nil {
+		t.Error("--units-format metric accepted")
+	}
+	setFlag(t, unitsFormat, unitsSI)
+	setFlag(t, secondsAbove, -time.Second)
+	if checkUnits() == nil {
+		t.Error("negative --seconds-above accepted")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// voipScore estimates call quality from latency, jitter and loss with the
+// simplified ITU-T G.107 E-model commonly used by network monitors: jitter
+// counts double and 10ms is added for codec delay, the R-factor drops
+// slowly up to 160ms of effective latency and steeply after, and each
+// percent of loss costs 2.5 points. The R-factor is mapped to a MOS
+// between 1 and 4.5. The round trip is used as the latency, which is
+// pessimistic for one-way audio but matches what a port ping measures.
+func voipScore(latency, jitter time.Duration, lossPct float64) (rFactor, mos float64) {
+	effective := ms(latency) + 2*ms(jitter) + 10
+	if effective < 160 {
+		rFactor = 93.2 - effective/40
+	} else {
+		rFactor = 93.2 - (effective-120)/10
+	}
+	rFactor -= 2.5 * lossPct
+	rFactor = math.Max(0, math.Min(100, rFactor))
+
+	mos = 1 + 0.035*rFactor + 7e-6*rFactor*(rFactor-60)*(100-rFactor)
+	return rFactor, math.Max(1, math.Min(4.5, mos))
+}
+
+// voipVerdict names the quality band of a MOS as used for G.711 calls.
+func voipVerdict(mos float64) string {
+	switch {
+	case mos >= 4.3:
+		return color.GreenString("excellent")
+	case mos >= 4.0:
+		return color.GreenString("good")
+	case mos >= 3.6:
+		return color.YellowString("fair")
+	case mos >= 3.1:
+		return color.YellowString("poor")
+	}
+	return color.RedString("bad")
+}
+
+func printVoIP(latency, jitter time.Duration, lossPct float64) {
+	r, mos := voipScore(latency, jitter, lossPct)
+	logger.Printf("VoIP quality estimate: MOS = "+color.CyanString("%.2f")+", R-factor = "+color.CyanString("%.1f")+" (%s), Jitter = "+color.CyanString("%s")+"\n",
+		mos, r, voipVerdict(mos), fmtMs(ms(jitter)))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestVoIPScore(t *testing.T) {
+	tests := []struct {
+		name            string
+		latency, jitter time.Duration
+		loss            float64
+		wantR, wantMOS  float64
+	}{
+		{"perfect", 0, 0, 0, 92.95, 4.404},
+		{"below the knee", 100 * time.Millisecond, 10 * time.Millisecond, 0, 89.95, 4.338},
+		{"above the knee", 200 * time.Millisecond, 0, 0, 84.2, 4.172},
+		{"loss", 0, 0, 4, 82.95, 4.130},
+		{"unusable", 2 * time.Second, 0, 50, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, mos := voipScore(tt.latency, tt.jitter, tt.loss)
+			if math.Abs(r-tt.wantR) > 0.01 {
+				t.Errorf("R-factor = %.3f, want %.2f", r, tt.wantR)
+			}
+			if math.Abs(mos-tt.wantMOS) > 0.001 {
+				t.Errorf("MOS = %.4f, want %.3f", mos, tt.wantMOS)
+			}
+		})
+	}
+}
+
+func TestVoIPScoreJitterCountsDouble(t *testing.T) {
+	r1, _ := voipScore(40*time.Millisecond, 10*time.Millisecond, 0)
+	r2, _ := voipScore(60*time.Millisecond, 0, 0)
+	if math.Abs(r1-r2) > 1e-9 {
+		t.Errorf("10ms of jitter gave R-factor %.3f, 20ms more latency %.3f", r1, r2)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// runWaitFor probes every target until each has accepted --consecutive
+// connections in a row, or until --timeout runs out. It reports whether
+// every target came up in time.
+func runWaitFor(targets []*Target) bool {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, t := range targets {
+			wg.Add(1)
+			go func(t *Target) {
+				defer wg.Done()
+				waitUp(t, stop)
+			}(t)
+		}
+		wg.Wait()
+		close(done)
+	}()
+
+	var deadline <-chan time.Time
+	if *waitTimeout > 0 {
+		deadline = time.After(*waitTimeout)
+	}
+	select {
+	case <-done:
+		logger.Printf(color.GreenString("All targets accepted %d consecutive connections\n", *consecutive))
+		return true
+	case <-deadline:
+		close(stop)
+		for _, t := range targets {
+			if !isUp(t) {
+				logger.Printf(color.RedString("Timed out after %s waiting for %s\n", *waitTimeout, t.Addr()))
+			}
+		}
+		return false
+	}
+}
+
+// waitUp probes t at --interval until --consecutive probes in a row have
+// succeeded or stop is closed.
+func waitUp(t *Target, stop <-chan struct{}) {
+	for streak, sent := 0, 0; streak < *consecutive; sent++ {
+		if sent > 0 {
+			select {
+			case <-stop:
+				return
+			case <-time.After(nextInterval()):
+			}
+		}
+		if ping(t).Success {
+			streak++
+		} else {
+			streak = 0
+		}
+	}
+}
+
+// isUp reports whether the last --consecutive probes of t all succeeded.
+func isUp(t *Target) bool {
+	stats := t.Stats
+	stats.Lock()
+	defer stats.Unlock()
+
+	n := len(stats.History)
+	if n < *consecutive {
+		return false
+	}
+	for _, d := range stats.History[n-*consecutive:] {
+		if d < 0 {
+			return false
+		}
+	}
+	return true
+}
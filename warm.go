@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// warmPings sends two application pings --warm-gap apart on a freshly
+// opened connection. Comparing the connect time with the second, warm
+// round trip separates handshake overhead from steady-state path RTT.
+func warmPings(conn net.Conn, r *Result) error {
+	first, err := roundTrip(conn)
+	if err != nil {
+		return fmt.Errorf("first ping: %w", err)
+	}
+	time.Sleep(*warmGap)
+	warm, err := roundTrip(conn)
+	if err != nil {
+		return fmt.Errorf("warm ping: %w", err)
+	}
+
+	r.FirstRTT = ms(first)
+	r.WarmRTT = ms(warm)
+	return nil
+}
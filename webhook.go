@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const signatureHeader = "X-Paping-Signature"
+
+// webhookSink POSTs results to a URL as JSON arrays, sending a batch once
+// it is full or the flush interval elapses, whichever comes first. Write
+// and Flush never wait for the endpoint, since they are called from the
+// probe loops: results that do not fit in the queue while it is slow are
+// dropped and counted in dropped.
+type webhookSink struct {
+	url      string
+	batch    int
+	interval time.Duration
+	secret   []byte
+	client   *http.Client
+
+	results chan Result
+	flush   chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+func newWebhookSink(url string, batch int, interval time.Duration, secret string) *webhookSink {
+	if batch < 1 {
+		batch = 1
+	}
+	w := &webhookSink{
+		url:      url,
+		batch:    batch,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		results:  make(chan Result, batch*4),
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if secret != "" {
+		w.secret = []byte(secret)
+	}
+	go w.loop()
+	return w
+}
+
+func (w *webhookSink) Write(r Result) error {
+	select {
+	case w.results <- r:
+	default:
+		w.dropped.Add(1)
+	}
+	return nil
+}
+
+// Flush has whatever is queued sent without waiting for the batch to
+// fill. It returns at once; a flush already pending covers this one.
+func (w *webhookSink) Flush() error {
+	select {
+	case w.flush <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (w *webhookSink) Close() error {
+	close(w.results)
+	<-w.done
+	w.reportDropped()
+	return nil
+}
+
+// reportDropped warns about the results dropped since it was last called.
+func (w *webhookSink) reportDropped() {
+	if n := w.dropped.Swap(0); n > 0 {
+		diag.Warn(fmt.Sprintf("Webhook: dropped %d results while %s was slow to answer", n, w.url), "url", w.url, "dropped", n)
+	}
+}
+
+func (w *webhookSink) loop() {
+	defer close(w.done)
+
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	pending := make([]Result, 0, w.batch)
+	for {
+		select {
+		case r, ok := <-w.results:
+			if !ok {
+				w.send(pending)
+				return
+			}
+			pending = append(pending, r)
+			if len(pending) >= w.batch {
+				w.send(pending)
+				pending = pending[:0]
+			}
+		case <-w.flush:
+			w.send(pending)
+			pending = pending[:0]
+		case <-tick:
+			w.send(pending)
+			pending = pending[:0]
+		}
+	}
+}
+
+func (w *webhookSink) send(results []Result) {
+	w.reportDropped()
+	if len(results) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		diag.Error("Webhook", errKey, err, "url", w.url)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		diag.Error("Webhook", errKey, err, "url", w.url)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != nil {
+		req.Header.Set(signatureHeader, "sha256="+sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		diag.Error("Webhook", errKey, err, "url", w.url)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		diag.Error(fmt.Sprintf("Webhook: %s returned %s", w.url, resp.Status), "url", w.url, "status", resp.StatusCode)
+	}
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of body keyed with secret.
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
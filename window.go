@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// clockFormats are the layouts --start-at and --until accept; a time of
+// day without a date means its next occurrence.
+var clockFormats = []string{"2006-01-02 15:04", "2006-01-02 15:04:05", "15:04", "15:04:05"}
+
+// parseClock reads a --start-at or --until time, giving the first moment
+// matching it after after.
+func parseClock(spec string, after time.Time) (time.Time, error) {
+	for _, layout := range clockFormats {
+		t, err := time.ParseInLocation(layout, spec, time.Local)
+		if err != nil {
+			continue
+		}
+		if len(layout) > len("15:04:05") {
+			return t, nil
+		}
+		t = time.Date(after.Year(), after.Month(), after.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+		if !t.After(after) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, want HH:MM[:SS] or YYYY-MM-DD HH:MM[:SS]", spec)
+}
+
+// runWindow returns when the run should start and, if it is limited, when
+// it should end: at --until or after --duration, whichever comes first.
+func runWindow(now time.Time) (start, end time.Time, err error) {
+	start = now
+	if *startAt != "" {
+		if start, err = parseClock(*startAt, now); err != nil {
+			return
+		}
+	}
+	if *runDuration > 0 {
+		end = start.Add(*runDuration)
+	}
+	if *untilTime != "" {
+		var u time.Time
+		if u, err = parseClock(*untilTime, start); err != nil {
+			return
+		}
+		if end.IsZero() || u.Before(end) {
+			end = u
+		}
+	}
+	if !end.IsZero() && !end.After(start) {
+		err = fmt.Errorf("--until %s is before --start-at", *untilTime)
+	}
+	return
+}
+
+// waitForWindow holds the run back until --start-at and arranges for every
+// target to stop at the end of the window; --count still ends a target
+// earlier if it is used up first. It returns false if the targets were
+// stopped while waiting to start.
+func waitForWindow(targets []*Target) bool {
+	start, end, _ := runWindow(time.Now())
+	if wait := time.Until(start); wait > 0 {
+		logger.Printf("Waiting until %s to start\n", start.Format("2006-01-02 15:04:05"))
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		// stopOnSignal stops every target together, so watching one will do.
+		select {
+		case <-timer.C:
+		case <-targets[0].ctx.Done():
+			return false
+		}
+	}
+	if !end.IsZero() {
+		time.AfterFunc(time.Until(end), func() {
+			for _, t := range targets {
+				t.Stop()
+			}
+		})
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+//go:build wireguard
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+	"paping/probe"
+)
+
+const wireguardMTU = 1420
+
+// wireguardConfig is a wg-quick style config reduced to what a userspace
+// tunnel needs: the interface addresses and the device settings in the
+// form the WireGuard UAPI takes them.
+type wireguardConfig struct {
+	addrs []netip.Addr
+	dns   []netip.Addr
+	mtu   int
+	iface strings.Builder
+	peers []*wireguardPeer
+}
+
+// wireguardPeer holds one [Peer] section. UAPI starts a peer with its
+// public key, so the key is kept apart from the rest of its settings.
+type wireguardPeer struct {
+	publicKey string
+	settings  strings.Builder
+}
+
+func (c *wireguardConfig) uapi() string {
+	var b strings.Builder
+	b.WriteString(c.iface.String())
+	for _, p := range c.peers {
+		b.WriteString(p.publicKey)
+		b.WriteString(p.settings.String())
+	}
+	return b.String()
+}
+
+// wireguardDialer brings up a userspace WireGuard tunnel from the config
+// at path and returns a DialContextFunc that connects through it. The
+// tunnel runs its own TCP/IP stack in process, so it needs neither root
+// nor a network interface; host names are still resolved outside it.
+func wireguardDialer(path string) (probe.DialContextFunc, error) {
+	cfg, err := readWireguardConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("WireGuard: %w", err)
+	}
+
+	tunDev, tnet, err := netstack.CreateNetTUN(cfg.addrs, cfg.dns, cfg.mtu)
+	if err != nil {
+		return nil, fmt.Errorf("WireGuard: %w", err)
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	if err := dev.IpcSet(cfg.uapi()); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("WireGuard: %w", err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("WireGuard: %w", err)
+	}
+	return tnet.DialContext, nil
+}
+
+func readWireguardConfig(path string) (*wireguardConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &wireguardConfig{mtu: wireguardMTU}
+	var section string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(line[1 : len(line)-1])
+			if section == "peer" {
+				cfg.peers = append(cfg.peers, &wireguardPeer{})
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want key = value", path, n)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if err := cfg.set(section, key, value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(cfg.addrs) == 0 {
+		return nil, fmt.Errorf("%s: [Interface] has no Address", path)
+	}
+	for _, p := range cfg.peers {
+		if p.publicKey == "" {
+			return nil, fmt.Errorf("%s: [Peer] has no PublicKey", path)
+		}
+	}
+	return cfg, nil
+}
+
+func (c *wireguardConfig) set(section, key, value string) error {
+	if section == "peer" {
+		return c.peers[len(c.peers)-1].set(key, value)
+	}
+	if section != "interface" {
+		return fmt.Errorf("unknown section [%s]", section)
+	}
+
+	switch key {
+	case "privatekey":
+		key, err := uapiKey("private_key", value)
+		if err != nil {
+			return err
+		}
+		c.iface.WriteString(key)
+	case "address":
+		for _, s := range strings.Split(value, ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+			if err != nil {
+				return err
+			}
+			c.addrs = append(c.addrs, prefix.Addr())
+		}
+	case "dns":
+		for _, s := range strings.Split(value, ",") {
+			// wg-quick also allows search domains here; only addresses matter.
+			if addr, err := netip.ParseAddr(strings.TrimSpace(s)); err == nil {
+				c.dns = append(c.dns, addr)
+			}
+		}
+	case "mtu":
+		mtu, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid MTU %q", value)
+		}
+		c.mtu = mtu
+	case "listenport":
+		fmt.Fprintf(&c.iface, "listen_port=%s\n", value)
+	default:
+		// Settings such as PostUp or Table only matter to wg-quick.
+	}
+	return nil
+}
+
+func (p *wireguardPeer) set(key, value string) error {
+	switch key {
+	case "publickey":
+		key, err := uapiKey("public_key", value)
+		if err != nil {
+			return err
+		}
+		p.publicKey = key
+	case "presharedkey":
+		key, err := uapiKey("preshared_key", value)
+		if err != nil {
+			return err
+		}
+		p.settings.WriteString(key)
+	case "endpoint":
+		addr, err := net.ResolveUDPAddr("udp", value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&p.settings, "endpoint=%s\n", addr)
+	case "allowedips":
+		for _, s := range strings.Split(value, ",") {
+			fmt.Fprintf(&p.settings, "allowed_ip=%s\n", strings.TrimSpace(s))
+		}
+	case "persistentkeepalive":
+		fmt.Fprintf(&p.settings, "persistent_keepalive_interval=%s\n", value)
+	}
+	return nil
+}
+
+// uapiKey turns a base64 key from the config into the hex UAPI line.
+func uapiKey(name, value string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != 32 {
+		return "", fmt.Errorf("invalid %s", strings.ReplaceAll(name, "_", " "))
+	}
+	return name + "=" + hex.EncodeToString(key) + "\n", nil
+}
@@ -0,0 +1,13 @@
+//go:build !wireguard
+
+package main
+
+import (
+	"errors"
+
+	"paping/probe"
+)
+
+func wireguardDialer(path string) (probe.DialContextFunc, error) {
+	return nil, errors.New("this build has no WireGuard support; rebuild with -tags wireguard")
+}